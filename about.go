@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+var remoteQuota = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "rclone_remote_quota_bytes",
+		Help: "Quota information reported by the remote's About call, by type (total, used, free, trashed, other)",
+	},
+	[]string{"remote", "type"},
+)
+
+func init() {
+	mustRegisterRemoteVec(remoteQuota, "remote", "type")
+}
+
+// updateRemoteAbout fetches the remote's quota information using About and updates its quota
+// metrics. Remotes whose backend doesn't support About are skipped
+func updateRemoteAbout(ctx context.Context, remoteCfg remoteConfig, opts *options) {
+	remote := remoteCfg.Name
+	contextLogger := logrus.WithField("remote", remote)
+	if err := opts.limiter.acquire(ctx, opts.clock); err != nil {
+		contextLogger.WithError(err).Error("failed waiting to fetch quota for remote")
+		recordRemoteError(remote, "about", err)
+		return
+	}
+	defer opts.limiter.release()
+	ctx, cancel := context.WithTimeout(ctx, remoteCfg.timeout(opts))
+	defer cancel()
+	ctx = withDNSTrace(ctx, remote, opts.clock)
+
+	f, err := remoteCfg.newFs(ctx, "")
+	if err != nil {
+		contextLogger.WithError(err).Error("failed creating Fs for remote")
+		recordRemoteError(remote, "about", err)
+		return
+	}
+	about := f.Features().About
+	if about == nil {
+		contextLogger.Debug("remote doesn't support About, skipping quota")
+		return
+	}
+	usage, err := about(ctx)
+	if err != nil {
+		contextLogger.WithError(err).Error("failed fetching quota for remote")
+		recordRemoteError(remote, "about", err)
+		return
+	}
+	for quotaType, value := range map[string]*int64{
+		"total":   usage.Total,
+		"used":    usage.Used,
+		"free":    usage.Free,
+		"trashed": usage.Trashed,
+		"other":   usage.Other,
+	} {
+		if value != nil {
+			remoteQuota.WithLabelValues(remote, quotaType).Set(float64(*value))
+		}
+	}
+	contextLogger.Info("updated remote quota metrics")
+}
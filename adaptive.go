@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// adaptiveTimeoutHistory is the number of recent count durations a bucket's adaptive timeout is
+// derived from
+const adaptiveTimeoutHistory = 5
+
+var bucketAdaptiveTimeouts = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "rclone_bucket_adaptive_timeouts_total",
+		Help: "Number of bucket counts that hit their adaptive timeout",
+	},
+	[]string{"remote", "bucket"},
+)
+
+func init() {
+	mustRegisterRemoteVec(bucketAdaptiveTimeouts, "remote", "bucket")
+}
+
+// adaptiveTimeout returns the bucket's count timeout as opts.adaptiveTimeoutMultiplier times the
+// mean of its recent count durations, bounded by the floor and ceiling. Buckets without any
+// successful count yet get the ceiling
+func adaptiveTimeout(remote, bucketName string, opts *options) time.Duration {
+	var durations []time.Duration
+	state.bucket(remote, bucketName, func(b *bucketState) {
+		durations = b.countDurations
+	})
+	if len(durations) == 0 {
+		return opts.adaptiveTimeoutMax
+	}
+	var total time.Duration
+	for _, d := range durations {
+		total += d
+	}
+	timeout := time.Duration(float64(total) / float64(len(durations)) * opts.adaptiveTimeoutMultiplier)
+	return min(max(timeout, opts.adaptiveTimeoutMin), opts.adaptiveTimeoutMax)
+}
+
+// recordCountDuration remembers how long a successful count of the bucket took
+func recordCountDuration(remote, bucketName string, d time.Duration) {
+	state.bucket(remote, bucketName, func(b *bucketState) {
+		b.countDurations = append(b.countDurations, d)
+		if len(b.countDurations) > adaptiveTimeoutHistory {
+			b.countDurations = b.countDurations[len(b.countDurations)-adaptiveTimeoutHistory:]
+		}
+	})
+}
+
+// isAdaptiveTimeout reports whether a count run with countCtx failed because of its own adaptive
+// deadline rather than the deadline of the remote's scrape
+func isAdaptiveTimeout(ctx, countCtx context.Context) bool {
+	return ctx.Err() == nil && countCtx.Err() == context.DeadlineExceeded
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sirupsen/logrus"
+)
+
+var aggregateDownstreamUp = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "rclone_aggregate_downstream_up",
+		Help: "Whether the last fetch of a downstream exporter's metrics succeeded",
+	},
+	[]string{"downstream"},
+)
+
+func init() {
+	prometheus.MustRegister(aggregateDownstreamUp)
+}
+
+// downstream is another exporter instance whose metrics are aggregated
+type downstream struct {
+	// url is the downstream's metrics endpoint
+	url string
+	// instance labels the downstream's metrics, its host and port
+	instance string
+}
+
+// aggregatingGatherer fetches the metrics of downstream exporter instances and merges them,
+// labelling each downstream's metrics with its instance
+type aggregatingGatherer struct {
+	client      *http.Client
+	label       string
+	downstreams []downstream
+}
+
+// newAggregatingGatherer returns a gatherer for the comma separated downstream URLs, defaulting
+// their path to /metrics
+func newAggregatingGatherer(urls, label string, timeout time.Duration) (*aggregatingGatherer, error) {
+	g := &aggregatingGatherer{
+		client: &http.Client{Timeout: timeout, Transport: newExporterTransport()},
+		label:  label,
+	}
+	for _, raw := range strings.Split(urls, ",") {
+		u, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("downstream %q must be an http or https URL", raw)
+		}
+		if u.Path == "" || u.Path == "/" {
+			u.Path = "/metrics"
+		}
+		g.downstreams = append(g.downstreams, downstream{url: u.String(), instance: u.Host})
+	}
+	return g, nil
+}
+
+// Gather implements prometheus.Gatherer. Downstreams that can't be fetched are left out and marked
+// down in rclone_aggregate_downstream_up rather than failing the whole response
+func (g *aggregatingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	results := make([]map[string]*dto.MetricFamily, len(g.downstreams))
+	var wg sync.WaitGroup
+	for i, d := range g.downstreams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			families, err := g.fetch(d)
+			if err != nil {
+				logrus.WithField("downstream", d.url).WithError(err).Error("failed fetching downstream metrics")
+				aggregateDownstreamUp.WithLabelValues(d.url).Set(0)
+				return
+			}
+			aggregateDownstreamUp.WithLabelValues(d.url).Set(1)
+			results[i] = families
+		}()
+	}
+	wg.Wait()
+
+	merged := map[string]*dto.MetricFamily{}
+	for i, families := range results {
+		for name, family := range families {
+			for _, metric := range family.Metric {
+				addLabel(metric, g.label, g.downstreams[i].instance)
+			}
+			if existing, ok := merged[name]; ok {
+				existing.Metric = append(existing.Metric, family.Metric...)
+				continue
+			}
+			merged[name] = family
+		}
+	}
+	names := make([]string, 0, len(merged))
+	for name := range merged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make([]*dto.MetricFamily, 0, len(names))
+	for _, name := range names {
+		out = append(out, merged[name])
+	}
+	return out, nil
+}
+
+// fetch fetches and parses the metrics of a downstream
+func (g *aggregatingGatherer) fetch(d downstream) (map[string]*dto.MetricFamily, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, d.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", string(expfmt.NewFormat(expfmt.TypeTextPlain)))
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var parser expfmt.TextParser
+	return parser.TextToMetricFamilies(resp.Body)
+}
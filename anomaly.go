@@ -0,0 +1,52 @@
+package main
+
+import (
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var bucketFileCountAnomaly = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "rclone_bucket_file_count_anomaly",
+		Help: "Whether a bucket's file count deviates from its rolling baseline by more than the configured percentage",
+	},
+	[]string{"remote", "bucket"},
+)
+
+func init() {
+	mustRegisterRemoteVec(bucketFileCountAnomaly, "remote", "bucket")
+}
+
+// isAnomaly reports whether count deviates from the mean of baseline by more than threshold
+// percent. An empty baseline is never anomalous
+func isAnomaly(count int64, baseline []int64, threshold float64) bool {
+	if len(baseline) == 0 {
+		return false
+	}
+	var sum int64
+	for _, c := range baseline {
+		sum += c
+	}
+	mean := float64(sum) / float64(len(baseline))
+	if mean == 0 {
+		return count != 0
+	}
+	return math.Abs(float64(count)-mean)/mean*100 > threshold
+}
+
+// updateFileCountAnomaly compares the bucket's file count to the rolling baseline of its previous
+// opts.anomalyWindow counts, then adds the count to the baseline
+func updateFileCountAnomaly(remote, bucketName string, files int64, opts *options) {
+	state.bucket(remote, bucketName, func(b *bucketState) {
+		anomaly := 0.0
+		if isAnomaly(files, b.fileCounts, opts.anomalyThreshold) {
+			anomaly = 1
+		}
+		bucketFileCountAnomaly.WithLabelValues(remote, bucketName).Set(anomaly)
+		b.fileCounts = append(b.fileCounts, files)
+		if len(b.fileCounts) > opts.anomalyWindow {
+			b.fileCounts = b.fileCounts[len(b.fileCounts)-opts.anomalyWindow:]
+		}
+	})
+}
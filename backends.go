@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fspath"
+)
+
+var exporterBackendInfo = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "rclone_exporter_backend_info",
+		Help: "Backends compiled into the exporter, always 1",
+	},
+	[]string{"backend"},
+)
+
+func init() {
+	prometheus.MustRegister(exporterBackendInfo)
+}
+
+// updateBackendInfo exports the backends registered by the blank imported backend packages
+func updateBackendInfo() {
+	for _, info := range fs.Registry {
+		exporterBackendInfo.WithLabelValues(info.Name).Set(1)
+	}
+}
+
+// remoteBackend returns the name of the backend the remote is configured with, e.g. "s3" for both
+// ":s3:" and a config section with type = s3. Local paths use the local backend
+func remoteBackend(remote string) (string, error) {
+	parsed, err := fspath.Parse(remote)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case parsed.Name == "":
+		return "local", nil
+	case strings.HasPrefix(parsed.Name, ":"):
+		return parsed.Name[1:], nil
+	}
+	backend, ok := fs.ConfigMap("", nil, parsed.Name, parsed.Config).Get("type")
+	if !ok {
+		return "", fmt.Errorf("%w (%q)", fs.ErrorNotFoundInConfigFile, parsed.Name)
+	}
+	return backend, nil
+}
+
+// checkRemoteBackends returns an error naming the first remote whose backend isn't compiled in.
+// Remotes missing from the rclone config are left to -on-missing-remote
+func checkRemoteBackends(remotes []remoteConfig) error {
+	for _, remote := range remotes {
+		if isMissingRemote(remote.Name) {
+			continue
+		}
+		backend, err := remoteBackend(remote.Name)
+		if err != nil {
+			return fmt.Errorf("invalid remote %q: %w", remote.Name, err)
+		}
+		if _, err := fs.Find(backend); err != nil {
+			return fmt.Errorf("remote %q needs backend %q which isn't compiled in", remote.Name, backend)
+		}
+	}
+	return nil
+}
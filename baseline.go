@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+var bucketSizeVsBaseline = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "rclone_bucket_size_vs_baseline_ratio",
+		Help: "Size of a bucket in its first count since startup divided by its size in the -baseline-file snapshot saved before the restart",
+	},
+	[]string{"remote", "bucket"},
+)
+
+func init() {
+	mustRegisterRemoteVec(bucketSizeVsBaseline, "remote", "bucket")
+}
+
+// baselineBucket is a bucket's last good count as saved in the -baseline-file snapshot
+type baselineBucket struct {
+	Remote string    `json:"remote"`
+	Bucket string    `json:"bucket"`
+	Size   int64     `json:"size"`
+	Files  int64     `json:"files"`
+	Time   time.Time `json:"time"`
+}
+
+// baseline compares the first count of each bucket since startup with the snapshot saved by the
+// previous run, and saves the snapshot the next run compares with. A nil baseline does nothing
+type baseline struct {
+	path string
+	mu   sync.Mutex
+	// pending holds the snapshot's buckets that haven't been counted since startup
+	pending map[bucketKey]baselineBucket
+}
+
+// loadBaseline reads the snapshot at path. A missing snapshot, as on the first run, leaves nothing
+// to compare with
+func loadBaseline(path string) (*baseline, error) {
+	b := &baseline{path: path, pending: map[bucketKey]baselineBucket{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		logrus.WithField("path", path).Info("no baseline snapshot yet, bucket counts will be compared from the next restart")
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	buckets := []baselineBucket{}
+	if err := json.Unmarshal(data, &buckets); err != nil {
+		return nil, err
+	}
+	for _, bucket := range buckets {
+		b.pending[bucketKey{remote: bucket.Remote, bucket: bucket.Bucket}] = bucket
+	}
+	logrus.WithFields(logrus.Fields{
+		"path":    path,
+		"buckets": len(buckets),
+	}).Info("loaded baseline snapshot")
+	return b, nil
+}
+
+// compare exports the ratio of the bucket's size to its baseline the first time it's counted since
+// startup. Buckets that are new since the snapshot, or that were empty in it, have no ratio
+func (b *baseline) compare(remote, bucketName string, size int64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := bucketKey{remote: remote, bucket: bucketName}
+	previous, ok := b.pending[key]
+	if !ok {
+		return
+	}
+	delete(b.pending, key)
+	contextLogger := logrus.WithFields(logrus.Fields{
+		"remote":        remote,
+		"bucket":        bucketName,
+		"size":          size,
+		"baseline_size": previous.Size,
+		"baseline_time": previous.Time,
+	})
+	var ratio float64
+	switch {
+	case previous.Size > 0:
+		ratio = float64(size) / float64(previous.Size)
+	case size == 0:
+		ratio = 1
+	default:
+		contextLogger.Info("bucket was empty in the baseline snapshot, not comparing it")
+		return
+	}
+	bucketSizeVsBaseline.WithLabelValues(remote, bucketName).Set(ratio)
+	contextLogger.WithField("ratio", ratio).Debug("compared bucket with baseline snapshot")
+}
+
+// save writes the last good count of every bucket to the snapshot, replacing it atomically. Buckets
+// of the previous snapshot that haven't been counted since startup are kept, unless their remote
+// has since been scraped successfully without them, meaning they're gone
+func (b *baseline) save() error {
+	if b == nil {
+		return nil
+	}
+	buckets := []baselineBucket{}
+	succeeded := map[string]bool{}
+	state.mu.Lock()
+	for key, s := range state.buckets {
+		if s.sized {
+			buckets = append(buckets, baselineBucket{
+				Remote: key.remote,
+				Bucket: key.bucket,
+				Size:   s.lastSize,
+				Files:  s.lastFiles,
+				Time:   s.lastCountTime,
+			})
+		}
+	}
+	for remote, r := range state.remotes {
+		succeeded[remote] = r.everSucceeded
+	}
+	state.mu.Unlock()
+
+	b.mu.Lock()
+	for key, bucket := range b.pending {
+		if succeeded[key.remote] {
+			delete(b.pending, key)
+			continue
+		}
+		buckets = append(buckets, bucket)
+	}
+	b.mu.Unlock()
+	sort.Slice(buckets, func(i, j int) bool {
+		if buckets[i].Remote != buckets[j].Remote {
+			return buckets[i].Remote < buckets[j].Remote
+		}
+		return buckets[i].Bucket < buckets[j].Bucket
+	})
+
+	data, err := json.Marshal(buckets)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so a crash never leaves a truncated snapshot behind
+	tmp, err := os.CreateTemp(filepath.Dir(b.path), filepath.Base(b.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), b.path)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// checkCABundle checks that the file at path holds at least one PEM encoded certificate. rclone
+// exits instead of returning an error when it can't load a CA bundle while building a backend's
+// HTTP client, so bundles are checked upfront
+func checkCABundle(path string) error {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no PEM certificates found in %q", path)
+	}
+	return nil
+}
+
+// withCACert returns ctx configured to verify the TLS certificates of the remote's endpoints with
+// its own CA bundle, if it has one
+func (r *remoteConfig) withCACert(ctx context.Context) context.Context {
+	if r.CACert == "" {
+		return ctx
+	}
+	ctx, ci := fs.AddConfig(ctx)
+	ci.CaCert = []string{r.CACert}
+	return ctx
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
+)
+
+// defaultCanaryPath is the object written by the canary check when no path is configured
+const defaultCanaryPath = ".rclone-exporter-canary"
+
+// canaryConfig enables writing, reading back and deleting a tiny object in some buckets to verify
+// they are readable and writable, not just listable
+type canaryConfig struct {
+	// Buckets lists the buckets to run the canary check in
+	Buckets []string `yaml:"buckets" json:"buckets,omitempty"`
+	// Path is the path of the canary object within each bucket
+	Path string `yaml:"path" json:"path,omitempty"`
+}
+
+// enabled reports whether the canary check should run in the bucket
+func (c *canaryConfig) enabled(bucket string) bool {
+	return c != nil && slices.Contains(c.Buckets, bucket)
+}
+
+// objectPath returns the configured canary object path, or the default
+func (c *canaryConfig) objectPath() string {
+	if c.Path == "" {
+		return defaultCanaryPath
+	}
+	return c.Path
+}
+
+// runCanary uploads a tiny object to the bucket, downloads it to verify its contents and then
+// removes it again. The object is stamped with the time from clk
+func runCanary(ctx context.Context, bucketFs fs.Fs, path string, clk clock) (err error) {
+	now := clk.Now()
+	payload := []byte(now.UTC().Format(time.RFC3339Nano))
+	info := object.NewStaticObjectInfo(path, now, int64(len(payload)), true, nil, bucketFs)
+	o, err := bucketFs.Put(ctx, bytes.NewReader(payload), info)
+	if err != nil {
+		return fmt.Errorf("failed writing canary object: %w", err)
+	}
+	// Always clean up the canary, failing the check if it can't be removed
+	defer func() {
+		if removeErr := o.Remove(ctx); removeErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed removing canary object: %w", removeErr))
+		}
+	}()
+
+	reader, err := o.Open(ctx)
+	if err != nil {
+		return fmt.Errorf("failed opening canary object: %w", err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("failed reading canary object: %w", err)
+	}
+	if !bytes.Equal(data, payload) {
+		return errors.New("canary object contents don't match what was written")
+	}
+	return nil
+}
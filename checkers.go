@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/sirupsen/logrus"
+)
+
+// bucketCheckers splits opts.listingConcurrency between the remote's buckets by their size in the
+// previous scrape, so the biggest bucket gets all of it and smaller ones proportionally less, down
+// to 1. Buckets without a previous size get all of it. It returns nil when no concurrency is set,
+// leaving rclone's default
+func bucketCheckers(remote string, bucketNames []string, opts *options) map[string]int {
+	if opts.listingConcurrency <= 0 {
+		return nil
+	}
+	sizes := map[string]int64{}
+	var largest int64
+	for _, bucketName := range bucketNames {
+		state.bucket(remote, bucketName, func(b *bucketState) {
+			if b.sized {
+				sizes[bucketName] = b.lastSize
+				largest = max(largest, b.lastSize)
+			}
+		})
+	}
+	checkers := make(map[string]int, len(bucketNames))
+	for _, bucketName := range bucketNames {
+		size, ok := sizes[bucketName]
+		n := opts.listingConcurrency
+		if ok && largest > 0 {
+			n = 1 + int(float64(opts.listingConcurrency-1)*float64(size)/float64(largest))
+		}
+		checkers[bucketName] = n
+		logrus.WithFields(logrus.Fields{
+			"remote":   remote,
+			"bucket":   bucketName,
+			"checkers": n,
+		}).Debug("chose bucket listing concurrency")
+	}
+	return checkers
+}
+
+// withCheckers returns ctx configured to list with n concurrent checkers, or ctx itself when n is 0
+func withCheckers(ctx context.Context, n int) context.Context {
+	if n <= 0 {
+		return ctx
+	}
+	ctx, ci := fs.AddConfig(ctx)
+	ci.Checkers = n
+	return ctx
+}
@@ -0,0 +1,57 @@
+package main
+
+import "time"
+
+// clock abstracts the passage of time so time-dependent behaviour such as scheduling, staleness and
+// backoff can be driven deterministically by a fake clock in tests
+type clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// Since returns the time elapsed since t
+	Since(t time.Time) time.Duration
+	// After returns a channel receiving the time once d has elapsed
+	After(d time.Duration) <-chan time.Time
+	// NewTicker returns a ticker delivering ticks every d
+	NewTicker(d time.Duration) ticker
+}
+
+// ticker abstracts time.Ticker for clock
+type ticker interface {
+	// C returns the channel the ticks are delivered on
+	C() <-chan time.Time
+	// Stop turns off the ticker
+	Stop()
+}
+
+// realClock is the clock backed by the time package, used in production
+type realClock struct{}
+
+// Now implements clock
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Since implements clock
+func (realClock) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+// After implements clock
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// NewTicker implements clock
+func (realClock) NewTicker(d time.Duration) ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+// realTicker is the ticker returned by realClock
+type realTicker struct {
+	*time.Ticker
+}
+
+// C implements ticker
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeClock is a clock that only moves when advanced, firing the timers and tickers that come due
+type fakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	timers  []*fakeTimer
+	tickers []*fakeTicker
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+type fakeTicker struct {
+	clock  *fakeClock
+	period time.Duration
+	next   time.Time
+	c      chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	c := &fakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now implements clock
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Since implements clock
+func (c *fakeClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// After implements clock
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timer := &fakeTimer{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		timer.c <- c.now
+		return timer.c
+	}
+	c.timers = append(c.timers, timer)
+	c.cond.Broadcast()
+	return timer.c
+}
+
+// NewTicker implements clock
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{clock: c, period: d, next: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.tickers = append(c.tickers, t)
+	c.cond.Broadcast()
+	return t
+}
+
+// C implements ticker
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+// Stop implements ticker
+func (t *fakeTicker) Stop() {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, other := range c.tickers {
+		if other == t {
+			c.tickers = append(c.tickers[:i], c.tickers[i+1:]...)
+			break
+		}
+	}
+}
+
+// Advance moves the clock forward by d, firing the timers and tickers due by then. Like
+// time.Ticker, a ticker whose last tick hasn't been received drops the ticks after it
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.at.After(c.now) {
+			pending = append(pending, timer)
+			continue
+		}
+		timer.c <- c.now
+	}
+	c.timers = pending
+	for _, t := range c.tickers {
+		for !t.next.After(c.now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
+
+// waitForTickers blocks until n tickers are running, so the clock isn't advanced before the code
+// under test has started waiting on it
+func (c *fakeClock) waitForTickers(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.tickers) < n {
+		c.cond.Wait()
+	}
+}
+
+func TestWatchConfigDirPollsOnTicks(t *testing.T) {
+	dir := t.TempDir()
+	fragment := filepath.Join(dir, "remotes.yaml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(fragment, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("remotes:\n  - name: \"a:\"\n")
+	_, fingerprint, err := loadConfigDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clk := newFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	applied := make(chan []remoteConfig, 1)
+	configValid.Set(1)
+	check := func(remotes []remoteConfig) error {
+		for _, remote := range remotes {
+			if remote.Name == "rejected:" {
+				return errors.New("rejected")
+			}
+		}
+		return nil
+	}
+	go watchConfigDir(ctx, clk, dir, fingerprint, nil, check, func(remotes []remoteConfig) {
+		applied <- remotes
+	})
+	clk.waitForTickers(1)
+
+	// Nothing is reloaded until the next poll, however long the change has been made
+	write("remotes:\n  - name: \"a:\"\n  - name: \"b:\"\n")
+	clk.Advance(configPollInterval - time.Second)
+	select {
+	case <-applied:
+		t.Fatal("config reloaded before the poll interval elapsed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	clk.Advance(time.Second)
+	select {
+	case remotes := <-applied:
+		if len(remotes) != 2 {
+			t.Fatalf("reloaded %d remotes, want 2", len(remotes))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("config wasn't reloaded on the poll tick")
+	}
+
+	// A broken fragment, or one failing the startup checks, keeps the previous config and is
+	// reported as invalid on the next poll
+	for _, content := range []string{"remotes:\n  - bogus: true\n", "remotes:\n  - name: \"rejected:\"\n"} {
+		configValid.Set(1)
+		write(content)
+		clk.Advance(configPollInterval)
+		deadline := time.Now().Add(5 * time.Second)
+		for testutil.ToFloat64(configValid) != 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("invalid config %q wasn't reported", content)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		select {
+		case <-applied:
+			t.Fatalf("invalid config %q was applied", content)
+		default:
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fshttp"
+	"github.com/sirupsen/logrus"
+)
+
+var remoteClockSkew = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "rclone_remote_clock_skew_seconds",
+		Help: "Difference between the time reported by a remote's endpoint and the exporter's clock, positive when the endpoint is ahead, with a resolution of one second",
+	},
+	[]string{"remote"},
+)
+
+func init() {
+	mustRegisterRemoteVec(remoteClockSkew, "remote")
+}
+
+// clockSkewWarnThreshold is the skew above which age based metrics are warned to be unreliable
+const clockSkewWarnThreshold = time.Minute
+
+// errNoEndpoint is returned by remoteEndpoint for remotes without an HTTP endpoint
+var errNoEndpoint = errors.New("remote has no HTTP endpoint")
+
+// remoteEndpoint returns the base URL of the remote's HTTP endpoint, from its endpoint or url
+// option, or the default endpoint of backends that don't need one configured
+func remoteEndpoint(remote string) (string, error) {
+	fsInfo, configName, _, connectionStringConfig, err := fs.ParseRemote(remote)
+	if err != nil {
+		return "", err
+	}
+	config := fs.ConfigMap(fsInfo.Prefix, fsInfo.Options, configName, connectionStringConfig)
+	endpoint := ""
+	for _, key := range []string{"endpoint", "url"} {
+		if value, ok := config.Get(key); ok && value != "" {
+			endpoint = value
+			break
+		}
+	}
+	if endpoint == "" {
+		switch fsInfo.Name {
+		case "s3":
+			endpoint = "https://s3.amazonaws.com"
+			if region, ok := config.Get("region"); ok && region != "" {
+				endpoint = "https://s3." + region + ".amazonaws.com"
+			}
+		case "b2":
+			endpoint = "https://api.backblazeb2.com"
+		default:
+			return "", errNoEndpoint
+		}
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	return endpoint, nil
+}
+
+// updateClockSkew compares the Date header of a HEAD request to the remote's endpoint with the
+// exporter's clock. Any response carries the header, so it doesn't matter that the request isn't
+// authenticated. Remotes without an HTTP endpoint are skipped without exporting the metric
+func updateClockSkew(ctx context.Context, remoteCfg remoteConfig, opts *options) {
+	remote := remoteCfg.Name
+	contextLogger := logrus.WithField("remote", remote)
+	endpoint, err := remoteEndpoint(remote)
+	if errors.Is(err, errNoEndpoint) {
+		contextLogger.Debug("remote has no HTTP endpoint, skipping clock skew check")
+		return
+	}
+	if err != nil {
+		contextLogger.WithError(err).Error("failed finding remote endpoint for clock skew check")
+		recordRemoteError(remote, "clock_skew", err)
+		return
+	}
+	// Use the remote's CA bundle, headers, connect timeout and proxy like its backend does
+	ctx = remoteCfg.withConnectTimeout(remoteCfg.withHeaders(remoteCfg.withCACert(ctx)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, endpoint, nil)
+	if err != nil {
+		contextLogger.WithError(err).Error("failed creating clock skew request")
+		recordRemoteError(remote, "clock_skew", err)
+		return
+	}
+	start := opts.clock.Now()
+	resp, err := fshttp.NewClient(ctx).Do(req)
+	if err != nil {
+		contextLogger.WithError(err).Error("failed requesting remote endpoint for clock skew check")
+		recordRemoteError(remote, "clock_skew", err)
+		return
+	}
+	resp.Body.Close()
+	end := opts.clock.Now()
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		contextLogger.WithField("endpoint", endpoint).Debug("remote endpoint returned no usable Date header, skipping clock skew check")
+		return
+	}
+	// The server stamped the response somewhere during the request, most likely halfway through
+	skew := date.Sub(start.Add(end.Sub(start) / 2))
+	remoteClockSkew.WithLabelValues(remote).Set(skew.Seconds())
+	if skew > clockSkewWarnThreshold || skew < -clockSkewWarnThreshold {
+		contextLogger.WithField("skew", skew).Warn("remote's clock differs from the exporter's, age based metrics may be unreliable")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rclone/rclone/fs"
+)
+
+var remoteEffectiveConcurrency = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "rclone_remote_effective_concurrency",
+		Help: "Peak number of a remote's buckets counted simultaneously during its last scrape",
+	},
+	[]string{"remote"},
+)
+
+func init() {
+	mustRegisterRemoteVec(remoteEffectiveConcurrency, "remote")
+}
+
+// parseBackendConcurrency parses comma separated backend=limit pairs, e.g. "s3=16,sftp=1", into a
+// limiter per backend shared by every remote of that backend
+func parseBackendConcurrency(s string) (map[string]limiter, error) {
+	limiters := map[string]limiter{}
+	if s == "" {
+		return limiters, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		backend, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("%q must be backend=limit", pair)
+		}
+		if _, err := fs.Find(backend); err != nil {
+			return nil, fmt.Errorf("backend %q isn't compiled in", backend)
+		}
+		if _, ok := limiters[backend]; ok {
+			return nil, fmt.Errorf("backend %q is limited more than once", backend)
+		}
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return nil, fmt.Errorf("limit of backend %q must be a positive integer", backend)
+		}
+		limiters[backend] = newLimiter(limit)
+	}
+	return limiters, nil
+}
+
+// backendLimiter returns the limiter shared by the remotes of the remote's backend, nil when the
+// backend isn't limited
+func (o *options) backendLimiter(remote string) limiter {
+	if len(o.backendLimiters) == 0 {
+		return nil
+	}
+	backend, err := remoteBackend(remote)
+	if err != nil {
+		return nil
+	}
+	return o.backendLimiters[backend]
+}
+
+// peakTracker tracks the peak number of operations in flight at once
+type peakTracker struct {
+	mu     sync.Mutex
+	active int
+	peak   int
+}
+
+// start records an operation starting
+func (p *peakTracker) start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active++
+	p.peak = max(p.peak, p.active)
+}
+
+// done records an operation finishing
+func (p *peakTracker) done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active--
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rclone/rclone/fs"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+var configValid = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "rclone_exporter_config_valid",
+		Help: "Whether the last attempt to load the exporter's config succeeded (1) or failed validation (0), in which case the previous config is still running",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(configValid)
+}
+
+// configPollInterval is how often the config directory is checked for changes when reloading is enabled
+const configPollInterval = 10 * time.Second
+
+// remoteConfig is the configuration of a single monitored remote
+type remoteConfig struct {
+	// Name is the rclone remote to monitor, e.g. "b2:"
+	Name string `yaml:"name" json:"name"`
+	// Source is where the remote was defined, either "flag", "rclone config" when discovered with
+	// -all-remotes, or the path of a config fragment
+	Source string `yaml:"-" json:"source"`
+	// Buckets lists buckets known to exist in the remote. They are counted on their own when the
+	// credentials can't list the remote's root, as is common with bucket-scoped credentials
+	Buckets []string `yaml:"buckets" json:"buckets,omitempty"`
+	// DiscoveryPrefix is the path buckets are discovered under instead of the remote's root, so
+	// listing happens server side within it, e.g. "shared-bucket/tenants". The discovered
+	// directories are labelled with their full path
+	DiscoveryPrefix string `yaml:"discovery_prefix" json:"discovery_prefix,omitempty"`
+	// Include limits the discovered buckets to those matching one of these glob patterns. Under a
+	// DiscoveryPrefix the patterns match the names relative to it
+	Include []string `yaml:"include" json:"include,omitempty"`
+	// Exclude skips the discovered buckets matching any of these glob patterns
+	Exclude []string `yaml:"exclude" json:"exclude,omitempty"`
+	// PageSize overrides the number of entries requested per listing page on backends that
+	// support it (their list_chunk option). 0 keeps the backend's default
+	PageSize int `yaml:"page_size" json:"page_size,omitempty"`
+	// UpdatePeriod scrapes the remote less often than -update-period, rounded up to a multiple of it
+	UpdatePeriod time.Duration `yaml:"update_period" json:"update_period,omitempty"`
+	// Timeout bounds the remote's scrape, overriding -remote-timeout
+	Timeout time.Duration `yaml:"timeout" json:"timeout,omitempty"`
+	// ConnectTimeout bounds establishing each connection to the remote, including its TLS
+	// handshake, overriding -connect-timeout
+	ConnectTimeout time.Duration `yaml:"connect_timeout" json:"connect_timeout,omitempty"`
+	// BucketTimeouts bounds counting individual buckets, keyed by bucket name. Buckets without one
+	// are only bounded by the remote's timeout
+	BucketTimeouts map[string]time.Duration `yaml:"bucket_timeouts" json:"bucket_timeouts,omitempty"`
+	// FastList overrides -fast-list for the remote, controlling whether buckets are listed
+	// recursively in one go on backends that support it
+	FastList *bool `yaml:"fast_list" json:"fast_list,omitempty"`
+	// RegressionThreshold overrides -regression-threshold for the remote
+	RegressionThreshold float64 `yaml:"regression_threshold" json:"regression_threshold,omitempty"`
+	// DecryptNamesWith names a crypt remote layered over this remote, e.g. "secret:", whose password
+	// is used to label buckets with their decrypted names. Crypt remotes monitored directly already
+	// list decrypted names
+	DecryptNamesWith string `yaml:"decrypt_names_with" json:"decrypt_names_with,omitempty"`
+	// CACert is a PEM bundle of the CAs the remote's endpoints are verified against, overriding
+	// -ca-cert
+	CACert string `yaml:"ca_cert" json:"ca_cert,omitempty"`
+	// Headers are extra HTTP headers added to every request to the remote, e.g. an API key required
+	// by a gateway in front of it
+	Headers map[string]string `yaml:"headers" json:"headers,omitempty"`
+	// Sample estimates the size of the remote's buckets from a sample of their prefixes instead of
+	// counting every object, for buckets too big to count exactly
+	Sample bool `yaml:"sample" json:"sample,omitempty"`
+	// Paths lists paths within the remote to count independently of its buckets
+	Paths []pathConfig `yaml:"paths" json:"paths,omitempty"`
+	// Canary enables the canary object check for some of the remote's buckets
+	Canary *canaryConfig `yaml:"canary" json:"canary,omitempty"`
+	// Profile names the collection profile the remote is scraped with
+	Profile string `yaml:"profile" json:"profile,omitempty"`
+	// MinAge overrides -min-age for the remote
+	MinAge time.Duration `yaml:"min_age" json:"min_age,omitempty"`
+	// StorageClasses overrides -storage-classes for the remote
+	StorageClasses []string `yaml:"storage_classes" json:"storage_classes,omitempty"`
+	// ObjectFilters are named filters the objects of each bucket are matched against in a single
+	// walk, exporting the size and count of each filter's matches
+	ObjectFilters []objectFilterConfig `yaml:"object_filters" json:"object_filters,omitempty"`
+	// SummaryOnly exports only totals and a size distribution of the remote's buckets, leaving out
+	// every series of its individual buckets, for remotes with too many buckets to track one by one
+	SummaryOnly bool `yaml:"summary_only" json:"summary_only,omitempty"`
+
+	// profile is the resolved collection profile, nil when the remote has none
+	profile *profileConfig
+	// objectFilters holds ObjectFilters once compiled
+	objectFilters []objectFilter
+}
+
+// options returns the scrape options for the remote, with its profile's collectors if it has one
+// and its object filters
+func (r *remoteConfig) options(opts *options) *options {
+	if r.profile != nil {
+		opts = r.profile.apply(opts)
+	}
+	if len(r.objectFilters) > 0 {
+		withFilters := *opts
+		withFilters.objectFilters = r.objectFilters
+		opts = &withFilters
+	}
+	if len(r.StorageClasses) > 0 {
+		withClasses := *opts
+		withClasses.storageClasses = r.StorageClasses
+		opts = &withClasses
+	}
+	if r.MinAge > 0 {
+		withMinAge := *opts
+		withMinAge.minAge = r.MinAge
+		opts = &withMinAge
+	}
+	return opts
+}
+
+// filterBuckets returns the buckets matching the remote's include and exclude patterns, in order,
+// prefixed with the remote's discovery prefix
+func (r *remoteConfig) filterBuckets(buckets []string) []string {
+	retained := []string{}
+	for _, bucket := range buckets {
+		if len(r.Include) > 0 && !matchAny(r.Include, bucket) {
+			continue
+		}
+		if matchAny(r.Exclude, bucket) {
+			continue
+		}
+		retained = append(retained, path.Join(r.DiscoveryPrefix, bucket))
+	}
+	return retained
+}
+
+// matchAny reports whether name matches any of the glob patterns, which are validated on load
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// timeout returns the remote's scrape timeout, falling back to -remote-timeout
+func (r *remoteConfig) timeout(opts *options) time.Duration {
+	if r.Timeout > 0 {
+		return r.Timeout
+	}
+	return opts.remoteTimeout
+}
+
+// withConnectTimeout returns ctx configured with the remote's own connect timeout, if it has one.
+// rclone applies it to the TLS handshake as well as the dial
+func (r *remoteConfig) withConnectTimeout(ctx context.Context) context.Context {
+	if r.ConnectTimeout == 0 {
+		return ctx
+	}
+	ctx, ci := fs.AddConfig(ctx)
+	ci.ConnectTimeout = r.ConnectTimeout
+	return ctx
+}
+
+// withFastList returns ctx configured to list the remote recursively (rclone's --fast-list) when
+// enabled, or to never do so otherwise. rclone's recursive walk uses ListR whenever the backend
+// supports it, so disabling it means disabling the backend's ListR feature
+func (r *remoteConfig) withFastList(ctx context.Context, opts *options) context.Context {
+	fastList := opts.fastList
+	if r.FastList != nil {
+		fastList = *r.FastList
+	}
+	ctx, ci := fs.AddConfig(ctx)
+	ci.UseListR = fastList
+	if !fastList {
+		ci.DisableFeatures = append(slices.Clone(ci.DisableFeatures), "ListR")
+	}
+	return ctx
+}
+
+// regressionThreshold returns the percentage a bucket's count must drop by to be a regression,
+// falling back to -regression-threshold. 0 disables the check
+func (r *remoteConfig) regressionThreshold(opts *options) float64 {
+	if r.RegressionThreshold > 0 {
+		return r.RegressionThreshold
+	}
+	return opts.regressionThreshold
+}
+
+// backendOptions returns the backend options the remote's config overrides
+func (r *remoteConfig) backendOptions() (map[string]string, error) {
+	options := map[string]string{}
+	if r.PageSize > 0 {
+		fsInfo, _, _, _, err := fs.ParseRemote(r.Name)
+		if err != nil {
+			return nil, err
+		}
+		if fsInfo.Options.Get("list_chunk") == nil {
+			return nil, fmt.Errorf("page_size isn't supported by the %s backend", fsInfo.Name)
+		}
+		options["list_chunk"] = strconv.Itoa(r.PageSize)
+	}
+	return options, nil
+}
+
+// newFs creates a Fs for path within the remote. Backend options overridden by the remote's config
+// are passed as connection string parameters, e.g. "s3,list_chunk=500:bucket"
+func (r *remoteConfig) newFs(ctx context.Context, path string) (fs.Fs, error) {
+	options, err := r.backendOptions()
+	if err != nil {
+		return nil, err
+	}
+	ctx = r.withCACert(ctx)
+	ctx = r.withHeaders(ctx)
+	ctx = r.withConnectTimeout(ctx)
+	// Split the remote into its config name and the rest, skipping the leading colon of an on
+	// the fly backend such as ":s3:"
+	i := strings.Index(r.Name[1:], ":") + 1
+	if i == 0 || len(options) == 0 {
+		return fs.NewFs(ctx, r.Name+path)
+	}
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	name := r.Name[:i]
+	for _, key := range keys {
+		name += "," + key + "=" + options[key]
+	}
+	return fs.NewFs(ctx, name+r.Name[i:]+path)
+}
+
+// pathConfig is a path within a remote that is counted on its own, e.g. a tenant's prefix in a
+// shared bucket
+type pathConfig struct {
+	// Name is used as the name label of the path's metrics
+	Name string `yaml:"name" json:"name"`
+	// Path is the path within the remote, e.g. "bucket/app1"
+	Path string `yaml:"path" json:"path,omitempty"`
+}
+
+// configFragment is the contents of a single YAML file in the config directory
+type configFragment struct {
+	Profiles map[string]*profileConfig `yaml:"profiles"`
+	Remotes  []remoteConfig            `yaml:"remotes"`
+}
+
+// configDirFiles returns the sorted paths of the YAML fragments in dir
+func configDirFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		files = append(files, filepath.Join(dir, entry.Name()))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// loadConfigDir reads every YAML fragment in dir and returns the remotes they define along with a
+// fingerprint of the files' contents, used to detect changes
+func loadConfigDir(dir string) ([]remoteConfig, [sha256.Size]byte, error) {
+	var fingerprint [sha256.Size]byte
+	files, err := configDirFiles(dir)
+	if err != nil {
+		return nil, fingerprint, err
+	}
+	hash := sha256.New()
+	remotes := []remoteConfig{}
+	sources := map[string]string{}
+	profiles := map[string]*profileConfig{}
+	profileSources := map[string]string{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fingerprint, err
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00", file, len(data))
+		hash.Write(data)
+
+		fragment := configFragment{}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&fragment); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fingerprint, fmt.Errorf("failed parsing %s: %w", file, err)
+		}
+		for name, profile := range fragment.Profiles {
+			if source, ok := profileSources[name]; ok {
+				return nil, fingerprint, fmt.Errorf("profile %q is defined in both %s and %s", name, source, file)
+			}
+			if profile == nil {
+				profile = &profileConfig{}
+			}
+			if err := profile.validate(); err != nil {
+				return nil, fingerprint, fmt.Errorf("invalid profile %q in %s: %w", name, file, err)
+			}
+			profiles[name] = profile
+			profileSources[name] = file
+		}
+		for _, remote := range fragment.Remotes {
+			if remote.Name == "" {
+				return nil, fingerprint, fmt.Errorf("remote without a name in %s", file)
+			}
+			remote.DiscoveryPrefix = strings.Trim(remote.DiscoveryPrefix, "/")
+			if remote.RegressionThreshold < 0 {
+				return nil, fingerprint, fmt.Errorf("regression_threshold of remote %q in %s must be positive", remote.Name, file)
+			}
+			if remote.UpdatePeriod < 0 {
+				return nil, fingerprint, fmt.Errorf("update_period of remote %q in %s must be positive", remote.Name, file)
+			}
+			if remote.ConnectTimeout < 0 {
+				return nil, fingerprint, fmt.Errorf("connect_timeout of remote %q in %s must be positive", remote.Name, file)
+			}
+			if remote.MinAge < 0 {
+				return nil, fingerprint, fmt.Errorf("min_age of remote %q in %s must be positive", remote.Name, file)
+			}
+			if remote.PageSize < 0 {
+				return nil, fingerprint, fmt.Errorf("page_size of remote %q in %s must be positive", remote.Name, file)
+			}
+			if remote.CACert != "" {
+				if err := checkCABundle(remote.CACert); err != nil {
+					return nil, fingerprint, fmt.Errorf("ca_cert of remote %q in %s: %w", remote.Name, file, err)
+				}
+			}
+			for _, pattern := range append(append([]string{}, remote.Include...), remote.Exclude...) {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return nil, fingerprint, fmt.Errorf("invalid bucket pattern %q of remote %q in %s: %w", pattern, remote.Name, file, err)
+				}
+			}
+			for bucket, timeout := range remote.BucketTimeouts {
+				if timeout <= 0 {
+					return nil, fingerprint, fmt.Errorf("timeout of bucket %q of remote %q in %s must be positive", bucket, remote.Name, file)
+				}
+			}
+			for _, path := range remote.Paths {
+				if path.Name == "" || path.Path == "" {
+					return nil, fingerprint, fmt.Errorf("path of remote %q in %s needs both a name and a path", remote.Name, file)
+				}
+			}
+			if err := validateHeaders(remote.Headers); err != nil {
+				return nil, fingerprint, fmt.Errorf("headers of remote %q in %s: %w", remote.Name, file, err)
+			}
+			if slices.Contains(remote.StorageClasses, "") {
+				return nil, fingerprint, fmt.Errorf("empty storage class of remote %q in %s", remote.Name, file)
+			}
+			if remote.objectFilters, err = compileObjectFilters(remote.ObjectFilters); err != nil {
+				return nil, fingerprint, fmt.Errorf("remote %q in %s: %w", remote.Name, file, err)
+			}
+			if source, ok := sources[remote.Name]; ok {
+				return nil, fingerprint, fmt.Errorf("remote %q is defined in both %s and %s", remote.Name, source, file)
+			}
+			sources[remote.Name] = file
+			remote.Source = file
+			remotes = append(remotes, remote)
+		}
+	}
+	// Profiles can be defined in any fragment, so references are only resolved once all are read
+	for i, remote := range remotes {
+		if remote.Profile == "" {
+			continue
+		}
+		profile, ok := profiles[remote.Profile]
+		if !ok {
+			return nil, fingerprint, fmt.Errorf("remote %q in %s uses undefined profile %q", remote.Name, remote.Source, remote.Profile)
+		}
+		remotes[i].profile = profile
+		remotes[i].Sample = remote.Sample || profile.Sample
+	}
+	copy(fingerprint[:], hash.Sum(nil))
+	return remotes, fingerprint, nil
+}
+
+// mergeRemotes combines the remotes given on the command line with those from the config
+// directory, failing if the same remote is defined more than once. Remotes discovered in the
+// rclone config are replaced by explicit definitions of the same remote
+func mergeRemotes(flagRemotes, dirRemotes []remoteConfig) ([]remoteConfig, error) {
+	seen := map[string]int{}
+	merged := []remoteConfig{}
+	for _, remote := range append(append([]remoteConfig{}, flagRemotes...), dirRemotes...) {
+		if i, ok := seen[remote.Name]; ok {
+			switch {
+			case merged[i].Source == sourceRcloneConfig:
+				merged[i] = remote
+			case remote.Source != sourceRcloneConfig:
+				return nil, fmt.Errorf("remote %q is defined more than once", remote.Name)
+			}
+			continue
+		}
+		seen[remote.Name] = len(merged)
+		merged = append(merged, remote)
+	}
+	return merged, nil
+}
+
+// checkRemotes runs the checks the merged remotes must pass before they're scraped, at startup
+// and on every reload
+func checkRemotes(remotes []remoteConfig, opts *options) error {
+	if err := checkRemoteBackends(remotes); err != nil {
+		return err
+	}
+	if opts.onMissingRemote == missingRemoteFail {
+		if err := checkMissingRemotes(remotes); err != nil {
+			return fmt.Errorf("%w with -on-missing-remote=fail", err)
+		}
+	}
+	if opts.readOnly {
+		if err := checkReadOnly(remotes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// watchConfigDir polls dir for changes and calls apply with the newly merged remotes whenever the
+// fragments change. A config that fails to load, merge or pass the checks
+// run at startup is logged, reported by
+// rclone_exporter_config_valid and the previous one kept
+func watchConfigDir(ctx context.Context, clk clock, dir string, fingerprint [sha256.Size]byte, flagRemotes []remoteConfig, check func([]remoteConfig) error, apply func([]remoteConfig)) {
+	ticker := clk.NewTicker(configPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			dirRemotes, newFingerprint, err := loadConfigDir(dir)
+			if err != nil {
+				configValid.Set(0)
+				logrus.WithField("dir", dir).WithError(err).Error("failed reloading config directory, keeping previous config")
+				continue
+			}
+			if newFingerprint == fingerprint {
+				// A broken fragment may have been reverted to the running config
+				configValid.Set(1)
+				continue
+			}
+			remotes, err := mergeRemotes(flagRemotes, dirRemotes)
+			if err == nil {
+				err = check(remotes)
+			}
+			if err != nil {
+				configValid.Set(0)
+				logrus.WithField("dir", dir).WithError(err).Error("failed reloading config directory, keeping previous config")
+				continue
+			}
+			configValid.Set(1)
+			fingerprint = newFingerprint
+			apply(remotes)
+			logrus.WithFields(logrus.Fields{
+				"dir":     dir,
+				"remotes": len(remotes),
+			}).Info("reloaded config directory")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
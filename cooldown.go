@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/sirupsen/logrus"
+)
+
+var remoteRateLimitCooldown = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "rclone_remote_rate_limit_cooldown_seconds",
+		Help: "Length in seconds of the cooldown the remote's scrapes are suppressed for after it rate limited the exporter, 0 when not cooling down",
+	},
+	[]string{"remote"},
+)
+
+func init() {
+	mustRegisterRemoteVec(remoteRateLimitCooldown, "remote")
+}
+
+// rateLimitErrors are fragments of the errors backends return when rate limiting requests, matched
+// case insensitively. rclone's pacer already retries them, so by the time one surfaces the backend
+// has been throttling for a while
+var rateLimitErrors = []string{
+	"status code: 429",
+	"too many requests",
+	"too_many_requests",
+	"toomanyrequests",
+	"slowdown",
+	"ratelimitexceeded",
+	"userratelimitexceeded",
+	"requestlimitexceeded",
+	"throttl",
+}
+
+// isRateLimitError reports whether err was caused by the backend rate limiting requests
+func isRateLimitError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var retryAfter fserrors.RetryAfter
+	if errors.As(err, &retryAfter) {
+		return true
+	}
+	message := strings.ToLower(err.Error())
+	for _, fragment := range rateLimitErrors {
+		if strings.Contains(message, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitCooldown returns the cooldown after the given number of consecutive rate limited
+// scrapes, doubling from -rate-limit-cooldown up to -rate-limit-cooldown-max
+func rateLimitCooldown(streak int, opts *options) time.Duration {
+	cooldown := opts.rateLimitCooldown
+	for i := 1; i < streak && cooldown < opts.rateLimitCooldownMax; i++ {
+		cooldown *= 2
+	}
+	return min(cooldown, opts.rateLimitCooldownMax)
+}
+
+// updateCooldown starts a cooldown for the remote if it rate limited the exporter since its last
+// scrape, or ends its cooldowns once a scrape succeeds. Called with the state lock held once the
+// scrape is done
+func updateCooldown(remote string, r *remoteState, ok bool, opts *options) {
+	rateLimited := r.rateLimited
+	r.rateLimited = false
+	switch {
+	case rateLimited && opts.rateLimitCooldown > 0:
+		r.rateLimitStreak++
+		cooldown := rateLimitCooldown(r.rateLimitStreak, opts)
+		r.cooldownUntil = opts.clock.Now().Add(cooldown)
+		remoteRateLimitCooldown.WithLabelValues(remote).Set(cooldown.Seconds())
+		logrus.WithField("remote", remote).WithField("cooldown", cooldown).Warn("remote is rate limiting, suppressing scrapes")
+	case ok:
+		r.rateLimitStreak = 0
+		r.cooldownUntil = time.Time{}
+		remoteRateLimitCooldown.WithLabelValues(remote).Set(0)
+	}
+}
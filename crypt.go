@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// nameDecrypter decrypts names encrypted by a crypt remote, as implemented by the crypt backend
+type nameDecrypter interface {
+	DecryptFileName(encryptedFileName string) (string, error)
+}
+
+// newNameDecrypter creates the crypt remote to decrypt names with
+func newNameDecrypter(ctx context.Context, cryptRemote string) (nameDecrypter, error) {
+	f, err := fs.NewFs(ctx, cryptRemote)
+	if err != nil {
+		return nil, err
+	}
+	decrypter, ok := f.(nameDecrypter)
+	if !ok {
+		return nil, fmt.Errorf("remote %q isn't a crypt remote", cryptRemote)
+	}
+	return decrypter, nil
+}
+
+// decryptPath decrypts each segment of p on its own, keeping segments that aren't encrypted, such
+// as a bucket holding the crypt remote's files, as they are
+func decryptPath(decrypter nameDecrypter, p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		if decrypted, err := decrypter.DecryptFileName(segment); err == nil {
+			segments[i] = decrypted
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/sirupsen/logrus"
+)
+
+// sourceRcloneConfig is the Source of remotes discovered in the rclone config by -all-remotes
+const sourceRcloneConfig = "rclone config"
+
+// discoverySkippedBackends are the backends of remotes -all-remotes leaves out: those wrapping
+// other remotes, whose objects would be counted twice, and those without buckets worth counting
+var discoverySkippedBackends = map[string]bool{
+	"alias":    true,
+	"cache":    true,
+	"chunker":  true,
+	"combine":  true,
+	"compress": true,
+	"crypt":    true,
+	"hasher":   true,
+	"union":    true,
+	// The local backend's root is the whole filesystem, and memory remotes start out empty
+	"local":  true,
+	"memory": true,
+}
+
+// discoverRemotes returns every remote defined in the rclone config, or in its environment
+// variables, whose name matches pattern if given. Remotes of skipped backends and of backends that
+// aren't compiled in are left out
+func discoverRemotes(pattern *regexp.Regexp) []remoteConfig {
+	discovered := []remoteConfig{}
+	for _, remote := range config.GetRemotes() {
+		name := remote.Name + ":"
+		contextLogger := logrus.WithFields(logrus.Fields{"remote": name, "backend": remote.Type})
+		if pattern != nil && !pattern.MatchString(name) {
+			continue
+		}
+		if discoverySkippedBackends[remote.Type] {
+			contextLogger.Debug("not monitoring discovered remote of a skipped backend")
+			continue
+		}
+		if _, err := fs.Find(remote.Type); err != nil {
+			contextLogger.Warn("not monitoring discovered remote whose backend isn't compiled in")
+			continue
+		}
+		discovered = append(discovered, remoteConfig{Name: name, Source: sourceRcloneConfig})
+	}
+	return discovered
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var remoteDNSDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "rclone_remote_dns_duration_seconds",
+		Help:    "Time spent resolving the hostnames of a remote's backend endpoints",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 12),
+	},
+	[]string{"remote"},
+)
+
+func init() {
+	mustRegisterRemoteVec(remoteDNSDuration, "remote")
+}
+
+// withDNSTrace returns a context that records the DNS resolution time, as measured by clk, of every
+// HTTP request the backend makes with it. The trace hooks don't identify the lookup they belong to,
+// so concurrent lookups are matched up first in, first out, which is close enough for a latency
+// distribution
+func withDNSTrace(ctx context.Context, remote string, clk clock) context.Context {
+	var (
+		mu     sync.Mutex
+		starts []time.Time
+	)
+	observer := remoteDNSDuration.WithLabelValues(remote)
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			starts = append(starts, clk.Now())
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			if len(starts) == 0 {
+				return
+			}
+			observer.Observe(clk.Since(starts[0]).Seconds())
+			starts = starts[1:]
+		},
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+var eventsDropped = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "rclone_exporter_events_dropped_total",
+		Help: "Total number of bucket update events dropped because an /events client wasn't keeping up",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(eventsDropped)
+}
+
+// eventBuffer is the number of events buffered per client before new ones are dropped
+const eventBuffer = 64
+
+// bucketEvent is pushed to /events clients whenever a bucket's metrics are updated
+type bucketEvent struct {
+	Remote    string    `json:"remote"`
+	Bucket    string    `json:"bucket"`
+	Size      int64     `json:"size"`
+	Count     int64     `json:"count"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// eventBroadcaster fans bucket events out to every connected /events client. A nil broadcaster
+// drops every event
+type eventBroadcaster struct {
+	mu      sync.Mutex
+	clients map[chan bucketEvent]struct{}
+}
+
+func newEventBroadcaster() *eventBroadcaster {
+	return &eventBroadcaster{clients: map[chan bucketEvent]struct{}{}}
+}
+
+// publish sends the event to every client without blocking, dropping it for clients whose buffer
+// is full so a slow client can't hold up scrapes
+func (b *eventBroadcaster) publish(event bucketEvent) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for client := range b.clients {
+		select {
+		case client <- event:
+		default:
+			eventsDropped.Inc()
+		}
+	}
+}
+
+// subscribe returns a channel receiving every event published until unsubscribe is called with it
+func (b *eventBroadcaster) subscribe() chan bucketEvent {
+	client := make(chan bucketEvent, eventBuffer)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clients[client] = struct{}{}
+	return client
+}
+
+// unsubscribe stops sending events to the client
+func (b *eventBroadcaster) unsubscribe(client chan bucketEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.clients, client)
+}
+
+// eventsHandler streams bucket events to the client as Server-Sent Events, one JSON object per
+// event, until the client disconnects
+func eventsHandler(b *eventBroadcaster) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		controller := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		if err := controller.Flush(); err != nil {
+			logrus.WithError(err).Error("events connection doesn't support streaming")
+			return
+		}
+		client := b.subscribe()
+		defer b.unsubscribe(client)
+		for {
+			select {
+			case event := <-client:
+				data, err := json.Marshal(event)
+				if err != nil {
+					logrus.WithError(err).Error("failed encoding bucket event")
+					continue
+				}
+				if _, err := fmt.Fprintf(w, "event: bucket\ndata: %s\n\n", data); err != nil {
+					return
+				}
+				if err := controller.Flush(); err != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rclone/rclone/fs"
+)
+
+var remoteFeatures = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "rclone_remote_features",
+		Help: "Whether the remote's backend supports an optional feature (1) or not (0)",
+	},
+	[]string{"remote", "feature"},
+)
+
+func init() {
+	mustRegisterRemoteVec(remoteFeatures, "remote", "feature")
+}
+
+// updateFeatureMetrics exports which of the optional features the collectors rely on are supported
+// by the remote's Fs, explaining why some collectors export nothing for a remote
+func updateFeatureMetrics(remote string, f fs.Fs) {
+	features := f.Features()
+	for feature, supported := range map[string]bool{
+		"about":             features.About != nil,
+		"list_r":            features.ListR != nil,
+		"read_metadata":     features.ReadMetadata,
+		"bucket_based":      features.BucketBased,
+		"empty_directories": features.CanHaveEmptyDirectories,
+		"command":           features.Command != nil,
+	} {
+		value := 0.0
+		if supported {
+			value = 1
+		}
+		remoteFeatures.WithLabelValues(remote, feature).Set(value)
+	}
+}
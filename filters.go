@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/filter"
+)
+
+var (
+	bucketSizeByFilter = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_bucket_size_bytes_by_filter",
+			Help: "Total size in bytes of the objects in a bucket matching a named object filter",
+		},
+		[]string{"remote", "bucket", "filter"},
+	)
+	bucketFileCountByFilter = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_bucket_file_count_by_filter",
+			Help: "File count of the objects in a bucket matching a named object filter",
+		},
+		[]string{"remote", "bucket", "filter"},
+	)
+)
+
+func init() {
+	mustRegisterRemoteVec(bucketSizeByFilter, "remote", "bucket", "filter")
+	mustRegisterRemoteVec(bucketFileCountByFilter, "remote", "bucket", "filter")
+}
+
+// objectFilterConfig is a named set of rclone filter rules objects are matched against, e.g. to
+// account for images and logs separately within a bucket
+type objectFilterConfig struct {
+	// Name is used as the filter label of the filter's metrics
+	Name string `yaml:"name" json:"name"`
+	// Include lists rclone --include patterns, e.g. "*.jpg" or "/logs/**"
+	Include []string `yaml:"include" json:"include,omitempty"`
+	// Exclude lists rclone --exclude patterns
+	Exclude []string `yaml:"exclude" json:"exclude,omitempty"`
+}
+
+// objectFilter is a compiled objectFilterConfig
+type objectFilter struct {
+	name   string
+	filter *filter.Filter
+}
+
+// compileObjectFilters validates the filter configs and compiles their rules
+func compileObjectFilters(configs []objectFilterConfig) ([]objectFilter, error) {
+	filters := []objectFilter{}
+	seen := map[string]bool{}
+	for _, config := range configs {
+		if config.Name == "" {
+			return nil, fmt.Errorf("object filter without a name")
+		}
+		if seen[config.Name] {
+			return nil, fmt.Errorf("object filter %q is defined more than once", config.Name)
+		}
+		seen[config.Name] = true
+		f, err := filter.NewFilter(&filter.Options{
+			RulesOpt: filter.RulesOpt{
+				IncludeRule: config.Include,
+				ExcludeRule: config.Exclude,
+			},
+			MinAge:  fs.DurationOff,
+			MaxAge:  fs.DurationOff,
+			MinSize: -1,
+			MaxSize: -1,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invalid object filter %q: %w", config.Name, err)
+		}
+		filters = append(filters, objectFilter{name: config.Name, filter: f})
+	}
+	return filters, nil
+}
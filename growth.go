@@ -0,0 +1,31 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var bucketFileCountGrowth = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "rclone_bucket_file_count_growth_per_hour",
+		Help: "Change in a bucket's file count between its last two successful counts, normalized to per hour",
+	},
+	[]string{"remote", "bucket"},
+)
+
+func init() {
+	mustRegisterRemoteVec(bucketFileCountGrowth, "remote", "bucket")
+}
+
+// updateFileCountGrowth exports how fast the bucket's file count changed since its previous count
+// and records when this count was taken. Scrapes aren't evenly spaced, so the change is divided by
+// the actual time between counts. Called with the state lock held, before the bucket's last count
+// is replaced. The first count has nothing to compare with, so exports nothing
+func updateFileCountGrowth(remote, bucketName string, b *bucketState, files int64, now time.Time) {
+	if b.sized && now.After(b.lastCountTime) {
+		growth := float64(files-b.lastFiles) / now.Sub(b.lastCountTime).Hours()
+		bucketFileCountGrowth.WithLabelValues(remote, bucketName).Set(growth)
+	}
+	b.lastCountTime = now
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/rclone/rclone/fs"
+	"golang.org/x/net/http/httpguts"
+)
+
+// validateHeaders checks that the header names and values are valid in HTTP requests
+func validateHeaders(headers map[string]string) error {
+	for name, value := range headers {
+		if !httpguts.ValidHeaderFieldName(name) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if !httpguts.ValidHeaderFieldValue(value) {
+			return fmt.Errorf("invalid value of header %q", name)
+		}
+	}
+	return nil
+}
+
+// withHeaders returns ctx configured to add the remote's headers to every HTTP request its backend
+// makes, like rclone's --header
+func (r *remoteConfig) withHeaders(ctx context.Context) context.Context {
+	if len(r.Headers) == 0 {
+		return ctx
+	}
+	names := make([]string, 0, len(r.Headers))
+	for name := range r.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ctx, ci := fs.AddConfig(ctx)
+	headers := slices.Clone(ci.Headers)
+	for _, name := range names {
+		headers = append(headers, &fs.HTTPOption{Key: name, Value: r.Headers[name]})
+	}
+	ci.Headers = headers
+	return ctx
+}
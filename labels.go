@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"google.golang.org/protobuf/proto"
+)
+
+// bucketLabeler derives an extra label, such as the environment, from bucket names using a regex
+// with a single named capture group. The group's name is the label name
+type bucketLabeler struct {
+	re    *regexp.Regexp
+	name  string
+	group int
+}
+
+// newBucketLabeler compiles expr and validates it has exactly one named capture group whose name
+// is a valid label name not already used by the exporter's metrics, by Prometheus for histograms
+// and summaries, nor one of the extra reserved names, such as -host-label's
+func newBucketLabeler(expr string, reserved ...string) (*bucketLabeler, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	labeler := &bucketLabeler{re: re}
+	for i, name := range re.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		if labeler.name != "" {
+			return nil, fmt.Errorf("regex %q must have exactly one named capture group", expr)
+		}
+		labeler.name, labeler.group = name, i
+	}
+	if labeler.name == "" {
+		return nil, fmt.Errorf("regex %q must have exactly one named capture group", expr)
+	}
+	if !model.LabelName(labeler.name).IsValid() {
+		return nil, fmt.Errorf("capture group name %q isn't a valid label name", labeler.name)
+	}
+	if remoteVecLabels[labeler.name] || labeler.name == "le" || labeler.name == "quantile" || slices.Contains(reserved, labeler.name) {
+		return nil, fmt.Errorf("capture group name %q conflicts with an existing label", labeler.name)
+	}
+	return labeler, nil
+}
+
+// value returns the label value derived from bucket, or "" if it doesn't match
+func (l *bucketLabeler) value(bucket string) string {
+	match := l.re.FindStringSubmatch(bucket)
+	if match == nil {
+		return ""
+	}
+	return match[l.group]
+}
+
+// labelingGatherer adds the derived label to every metric with a bucket label gathered from the
+// wrapped Gatherer, so it applies to all bucket metrics without changing how they're defined
+type labelingGatherer struct {
+	prometheus.Gatherer
+	labeler *bucketLabeler
+}
+
+// Gather implements prometheus.Gatherer
+func (g labelingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			bucket, ok := "", false
+			for _, label := range metric.Label {
+				if label.GetName() == "bucket" {
+					bucket, ok = label.GetValue(), true
+				}
+			}
+			if !ok {
+				continue
+			}
+			metric.Label = append(metric.Label, &dto.LabelPair{
+				Name:  proto.String(g.labeler.name),
+				Value: proto.String(g.labeler.value(bucket)),
+			})
+			sort.Slice(metric.Label, func(i, j int) bool {
+				return metric.Label[i].GetName() < metric.Label[j].GetName()
+			})
+		}
+	}
+	return families, err
+}
+
+// hostLabelValue identifies the exporter replica, preferring the Kubernetes pod name
+func hostLabelValue() (string, error) {
+	for _, name := range []string{"POD_NAME", "HOSTNAME"} {
+		if value := os.Getenv(name); value != "" {
+			return value, nil
+		}
+	}
+	return os.Hostname()
+}
+
+// constLabelGatherer adds a label with a fixed value to every metric gathered from the wrapped
+// Gatherer, such as the host so the metrics of several replicas can be told apart. Metrics that
+// already have the label keep their own value
+type constLabelGatherer struct {
+	prometheus.Gatherer
+	name  string
+	value string
+}
+
+// Gather implements prometheus.Gatherer
+func (g constLabelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			addLabel(metric, g.name, g.value)
+		}
+	}
+	return families, err
+}
+
+// localLabels are the labels added to the exporter's own metrics as they're gathered
+type localLabels struct {
+	// labeler derives a label from bucket names, nil without -bucket-label-regex
+	labeler *bucketLabeler
+	// hostLabel names the label holding host, empty without -host-label
+	hostLabel string
+	host      string
+}
+
+// wrap returns a Gatherer adding the labels to the metrics gathered from g
+func (l localLabels) wrap(g prometheus.Gatherer) prometheus.Gatherer {
+	if l.labeler != nil {
+		g = labelingGatherer{Gatherer: g, labeler: l.labeler}
+	}
+	if l.hostLabel != "" {
+		g = constLabelGatherer{Gatherer: g, name: l.hostLabel, value: l.host}
+	}
+	return g
+}
+
+// addLabel adds the label to the metric unless it already has it, keeping the labels sorted
+func addLabel(metric *dto.Metric, name, value string) {
+	for _, label := range metric.Label {
+		if label.GetName() == name {
+			return
+		}
+	}
+	metric.Label = append(metric.Label, &dto.LabelPair{
+		Name:  proto.String(name),
+		Value: proto.String(value),
+	})
+	sort.Slice(metric.Label, func(i, j int) bool {
+		return metric.Label[i].GetName() < metric.Label[j].GetName()
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	concurrencyWait = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "rclone_exporter_concurrency_wait_seconds_total",
+			Help: "Total time spent waiting to acquire the shared and per backend concurrency limiters",
+		},
+	)
+	scrapeBacklog = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "rclone_exporter_scrape_backlog",
+			Help: "Number of remote scrapes due that haven't started yet, waiting for the concurrency limiter or, with -sequential, for the previous remotes",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(concurrencyWait)
+	prometheus.MustRegister(scrapeBacklog)
+}
+
+// limiter bounds the number of concurrent operations against the remotes. A nil limiter doesn't
+// limit anything
+type limiter chan struct{}
+
+// newLimiter returns a limiter allowing n concurrent operations, or nil if n isn't positive
+func newLimiter(n int) limiter {
+	if n <= 0 {
+		return nil
+	}
+	return make(limiter, n)
+}
+
+// acquire blocks until a slot is free or ctx is done. The time spent waiting, measured with clk, is
+// accumulated in concurrencyWait so chronic contention shows up even between scrapes
+func (l limiter) acquire(ctx context.Context, clk clock) error {
+	if l == nil {
+		return nil
+	}
+	start := clk.Now()
+	defer func() {
+		concurrencyWait.Add(clk.Since(start).Seconds())
+	}()
+	select {
+	case l <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees a slot taken by acquire
+func (l limiter) release() {
+	if l == nil {
+		return
+	}
+	<-l
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// unixListenPrefix marks a -listen address as the path of a Unix domain socket
+const unixListenPrefix = "unix:"
+
+// listen listens on addr, either a TCP address or a Unix domain socket path prefixed with "unix:".
+// A socket left behind by a previous run that didn't shut down cleanly is replaced, and the socket
+// is removed again when the listener is closed
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixListenPrefix)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%q exists and isn't a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
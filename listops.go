@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http/httptrace"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	remoteListOperations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rclone_remote_list_operations_total",
+			Help: "Total number of HTTP requests made listing a remote's buckets and counting or estimating their objects",
+		},
+		[]string{"remote"},
+	)
+	remoteDiscoveryPages = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_remote_discovery_pages",
+			Help: "Number of HTTP requests made listing a remote's buckets in its last scrape, not counting its buckets",
+		},
+		[]string{"remote"},
+	)
+)
+
+func init() {
+	mustRegisterRemoteVec(remoteListOperations, "remote")
+	mustRegisterRemoteVec(remoteDiscoveryPages, "remote")
+}
+
+// withListOperations returns a context that counts every HTTP request the backend makes with it as
+// a list operation of the remote. rclone's accounting doesn't count list requests, but each page of
+// a listing is a request, retries included, which is what metered backends bill for
+func withListOperations(ctx context.Context, remote string) context.Context {
+	counter := remoteListOperations.WithLabelValues(remote)
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			counter.Inc()
+		},
+	})
+}
+
+// withRequestCount returns a context that adds every HTTP request the backend makes with it to n,
+// for counting the requests of a single phase of a scrape
+func withRequestCount(ctx context.Context, n *atomic.Int64) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			n.Add(1)
+		},
+	})
+}
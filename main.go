@@ -2,14 +2,27 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"errors"
 	"flag"
+	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/model"
 	_ "github.com/rclone/rclone/backend/b2" // Import desired backends
+	_ "github.com/rclone/rclone/backend/crypt"
+	_ "github.com/rclone/rclone/backend/local"
 	_ "github.com/rclone/rclone/backend/s3"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/config/configfile"
@@ -34,11 +47,398 @@ var (
 		},
 		[]string{"remote", "bucket"},
 	)
+	pathSize = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_path_size_bytes",
+			Help: "Total size in bytes for a configured path",
+		},
+		[]string{"remote", "name"},
+	)
+	pathFileCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_path_file_count",
+			Help: "File count for a configured path",
+		},
+		[]string{"remote", "name"},
+	)
+	bucketSizeByMetadata = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_bucket_size_bytes_by_metadata",
+			Help: "Total size in bytes for a bucket grouped by the value of an object metadata key",
+		},
+		[]string{"remote", "bucket", "value"},
+	)
+	bucketFileCountByMetadata = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_bucket_file_count_by_metadata",
+			Help: "File count for a bucket grouped by the value of an object metadata key",
+		},
+		[]string{"remote", "bucket", "value"},
+	)
+	bucketScrapeFailed = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_bucket_scrape_failed",
+			Help: "Whether a bucket's last scrape failed, after any retries, staying set until a scrape of the bucket succeeds",
+		},
+		[]string{"remote", "bucket"},
+	)
+	bucketRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rclone_bucket_retries_total",
+			Help: "Total number of times counting a bucket was retried",
+		},
+		[]string{"remote", "bucket"},
+	)
+	bucketRetriesLastScrape = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_bucket_retries_last_scrape",
+			Help: "Number of times counting a bucket was retried during the last scrape",
+		},
+		[]string{"remote", "bucket"},
+	)
+	bucketCanarySuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_bucket_canary_success",
+			Help: "Whether the last canary object write, read and delete in a bucket succeeded",
+		},
+		[]string{"remote", "bucket"},
+	)
+	bucketCanaryLatency = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_bucket_canary_latency_seconds",
+			Help: "Duration of the last successful canary object round trip in a bucket",
+		},
+		[]string{"remote", "bucket"},
+	)
+	httpRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rclone_exporter_http_requests_total",
+			Help: "Total number of HTTP requests served by the exporter",
+		},
+		[]string{"path", "code"},
+	)
+	remoteErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rclone_remote_errors_total",
+			Help: "Total number of errors scraping a remote by the operation that failed",
+		},
+		[]string{"remote", "operation"},
+	)
+	remotesConfigured = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "rclone_exporter_remotes_configured",
+			Help: "Number of remotes configured to be monitored",
+		},
+	)
+	scrapeCycleTimeouts = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "rclone_scrape_cycle_timeouts",
+			Help: "Number of remotes whose scrape hit its deadline in the last cycle",
+		},
+	)
+	exporterStartTime = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "rclone_exporter_start_timestamp_seconds",
+			Help: "Unix time the exporter started at",
+		},
+	)
+	remotesHealthy = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "rclone_exporter_remotes_healthy",
+			Help: "Number of configured remotes whose last scrape succeeded",
+		},
+	)
+	bucketObjectsByAge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_bucket_objects_by_age",
+			Help: "Number of objects in a bucket by age tier, based on their modification time",
+		},
+		[]string{"remote", "bucket", "age"},
+	)
+	bucketBytesByAge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_bucket_bytes_by_age",
+			Help: "Total size in bytes of the objects in a bucket by age tier, based on their modification time",
+		},
+		[]string{"remote", "bucket", "age"},
+	)
+	bucketObjectsNoModTime = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_bucket_objects_no_modtime",
+			Help: "Number of objects in a bucket without a modification time, which are missing from the age metrics",
+		},
+		[]string{"remote", "bucket"},
+	)
+	remoteDuplicateBuckets = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_remote_duplicate_buckets",
+			Help: "Number of duplicate bucket entries the last listing of a remote returned, which are counted once",
+		},
+		[]string{"remote"},
+	)
+	remoteBucketsFiltered = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_remote_buckets_filtered",
+			Help: "Number of buckets discovered in a remote that were skipped by its include and exclude patterns",
+		},
+		[]string{"remote"},
+	)
+	bucketObjectsWithoutHash = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_bucket_objects_without_hash",
+			Help: "Number of objects in a bucket without a hash of the backend's preferred type, which rclone can't verify",
+		},
+		[]string{"remote", "bucket"},
+	)
+	bucketDirCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_bucket_dir_count",
+			Help: "Number of directories in a bucket",
+		},
+		[]string{"remote", "bucket"},
+	)
+	bucketSizeDiscrepancy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_bucket_size_discrepancy_bytes",
+			Help: "Size in bytes of a bucket as counted minus the sum of the object sizes found by walking it",
+		},
+		[]string{"remote", "bucket"},
+	)
+	bucketCountedObjects = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_bucket_counted_objects",
+			Help: "Number of objects found by walking a bucket, to compare with rclone_bucket_file_count",
+		},
+		[]string{"remote", "bucket"},
+	)
+	remoteRetryTime = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_remote_retry_time_seconds",
+			Help: "Time spent on failed bucket and path counts that were retried during the last scrape of a remote",
+		},
+		[]string{"remote"},
+	)
+	remoteNeverSucceeded = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_remote_never_succeeded",
+			Help: "Whether no scrape of a remote has succeeded since the exporter started",
+		},
+		[]string{"remote"},
+	)
+	remoteFailingSince = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_remote_failing_since_timestamp_seconds",
+			Help: "Unix timestamp of the start of the first scrape in a remote's current streak of failed scrapes, absent while it succeeds",
+		},
+		[]string{"remote"},
+	)
+	remoteFirstAttemptAge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_remote_first_attempt_age_seconds",
+			Help: "Time since the first scrape of a remote started, as of the end of its last scrape",
+		},
+		[]string{"remote"},
+	)
+	remoteSuccessRatio = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_remote_success_ratio",
+			Help: "Fraction of a remote's most recent scrapes, up to -success-window, that succeeded",
+		},
+		[]string{"remote"},
+	)
+	remoteListingPartial = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_remote_listing_partial",
+			Help: "Whether the last listing of a remote's buckets failed partway and only the buckets discovered before the error were counted",
+		},
+		[]string{"remote"},
+	)
+	remoteSlowestBucket = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_remote_slowest_bucket",
+			Help: "Duration in seconds of the slowest bucket in the last scrape of a remote, labelled with the bucket",
+		},
+		[]string{"remote", "bucket"},
+	)
+	bucketObjectSizeStddev = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_bucket_object_size_stddev_bytes",
+			Help: "Standard deviation of the sizes of the objects in a bucket",
+		},
+		[]string{"remote", "bucket"},
+	)
+	remoteScrapeDutyCycle = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_remote_scrape_duty_cycle",
+			Help: "Duration of the last scrape of a remote divided by the update period",
+		},
+		[]string{"remote"},
+	)
 )
 
 func init() {
-	prometheus.MustRegister(bucketSize)
-	prometheus.MustRegister(bucketFileCount)
+	mustRegisterRemoteVec(bucketSize, "remote", "bucket")
+	mustRegisterRemoteVec(bucketFileCount, "remote", "bucket")
+	mustRegisterRemoteVec(bucketScrapeFailed, "remote", "bucket")
+	mustRegisterRemoteVec(pathSize, "remote", "name")
+	mustRegisterRemoteVec(pathFileCount, "remote", "name")
+	mustRegisterRemoteVec(bucketSizeByMetadata, "remote", "bucket", "value")
+	mustRegisterRemoteVec(bucketFileCountByMetadata, "remote", "bucket", "value")
+	mustRegisterRemoteVec(bucketRetries, "remote", "bucket")
+	mustRegisterRemoteVec(bucketRetriesLastScrape, "remote", "bucket")
+	mustRegisterRemoteVec(bucketCanarySuccess, "remote", "bucket")
+	mustRegisterRemoteVec(bucketCanaryLatency, "remote", "bucket")
+	mustRegisterRemoteVec(remoteErrors, "remote", "operation")
+	prometheus.MustRegister(remotesConfigured)
+	prometheus.MustRegister(remotesHealthy)
+	prometheus.MustRegister(exporterStartTime)
+	prometheus.MustRegister(scrapeCycleTimeouts)
+	mustRegisterRemoteVec(bucketObjectsByAge, "remote", "bucket", "age")
+	mustRegisterRemoteVec(bucketBytesByAge, "remote", "bucket", "age")
+	mustRegisterRemoteVec(bucketObjectsNoModTime, "remote", "bucket")
+	mustRegisterRemoteVec(bucketObjectsWithoutHash, "remote", "bucket")
+	mustRegisterRemoteVec(bucketObjectSizeStddev, "remote", "bucket")
+	mustRegisterRemoteVec(bucketCountedObjects, "remote", "bucket")
+	mustRegisterRemoteVec(bucketDirCount, "remote", "bucket")
+	mustRegisterRemoteVec(bucketSizeDiscrepancy, "remote", "bucket")
+	mustRegisterRemoteVec(remoteBucketsFiltered, "remote")
+	mustRegisterRemoteVec(remoteDuplicateBuckets, "remote")
+	mustRegisterRemoteVec(remoteScrapeDutyCycle, "remote")
+	mustRegisterRemoteVec(remoteSlowestBucket, "remote", "bucket")
+	mustRegisterRemoteVec(remoteRetryTime, "remote")
+	mustRegisterRemoteVec(remoteNeverSucceeded, "remote")
+	mustRegisterRemoteVec(remoteFailingSince, "remote")
+	mustRegisterRemoteVec(remoteFirstAttemptAge, "remote")
+	mustRegisterRemoteVec(remoteSuccessRatio, "remote")
+	mustRegisterRemoteVec(remoteListingPartial, "remote")
+	prometheus.MustRegister(httpRequests)
+}
+
+// options holds the settings that control how remotes are scraped
+type options struct {
+	// clock is the source of time for scheduling and time-based state
+	clock clock
+	// updatePeriod is the time between scrapes of each remote
+	updatePeriod time.Duration
+	// remoteTimeout bounds each remote's scrape unless the remote overrides it
+	remoteTimeout time.Duration
+	// sequential processes remotes one at a time in order instead of concurrently
+	sequential bool
+	// limiter is shared by every operation against the remotes to bound their concurrency
+	limiter limiter
+	// events receives an event whenever a bucket's metrics are updated, nil when /events is
+	// disabled
+	events *eventBroadcaster
+	// baseline compares the first counts since startup with the snapshot saved before the
+	// restart, nil when -baseline-file isn't set
+	baseline *baseline
+	// backendLimiters bound the number of buckets and paths counted concurrently across all the
+	// remotes of a backend, keyed by backend name
+	backendLimiters map[string]limiter
+	// about enables fetching quota information with About
+	about bool
+	// anomalyWindow is the number of previous scrapes the file count anomaly baseline is built
+	// from. 0 disables anomaly detection
+	anomalyWindow int
+	// anomalyThreshold is the percentage a file count must deviate from its baseline to be anomalous
+	anomalyThreshold float64
+	// multipartUploads enables counting incomplete multipart uploads on backends that support it
+	multipartUploads bool
+	// proceedOnPartialListing counts the buckets discovered before a listing error instead of
+	// failing the remote
+	proceedOnPartialListing bool
+	// sampleAboveBuckets switches remotes with more buckets than this to sampling. 0 disables it
+	sampleAboveBuckets int
+	// maxBuckets fails the scrape of remotes with more buckets than this. 0 disables it
+	maxBuckets int
+	// samplePrefixes is the number of prefixes counted per bucket when estimating a sampled remote
+	samplePrefixes int
+	// alignSchedule aligns the periodic scrapes to boundaries of updatePeriod
+	alignSchedule bool
+	// onMissingRemote controls what happens to remotes whose section isn't in the rclone config
+	onMissingRemote missingRemotePolicy
+	// countRoot counts each remote's root as a single bucket instead of listing its buckets
+	countRoot bool
+	// rootBucketName is the bucket label of the root when countRoot is set
+	rootBucketName string
+	// listingConcurrency is the number of concurrent listings (rclone's checkers) the largest bucket
+	// of a remote is counted with, smaller buckets get proportionally fewer. 0 keeps rclone's default
+	listingConcurrency int
+	// regressionThreshold is the percentage a bucket's file count or size must drop by between
+	// scrapes to be flagged as a regression. 0 disables the check
+	regressionThreshold float64
+	// failOnRegression fails the bucket's scrape on a regression, keeping its last good counts
+	failOnRegression bool
+	// fastList lists buckets recursively on backends that support it unless a remote overrides it
+	fastList bool
+	// successWindow is the number of recent scrapes each remote's success ratio is computed over
+	successWindow int
+	// staleSeriesGraceCycles is the number of consecutive successful listings a bucket must be
+	// missing from before its series are deleted. 0 keeps them forever
+	staleSeriesGraceCycles int
+	// progressInterval publishes the running size and count of buckets being counted this often.
+	// 0 only publishes them once counted
+	progressInterval time.Duration
+	// stalestFirst scrapes the remotes, and the buckets of each remote, that succeeded longest ago
+	// first
+	stalestFirst bool
+	// readOnly keeps rclone and the collectors from modifying the remotes
+	readOnly bool
+	// bucketConcurrency is the number of a remote's buckets counted concurrently
+	bucketConcurrency int
+	// retries is the number of times a failed bucket count is retried
+	retries int
+	// metadataKey is the object metadata key to group bucket sizes by. Empty disables grouping
+	metadataKey string
+	// metadataTopN caps the number of distinct metadata values exported per bucket
+	metadataTopN int
+	// metadataMaxTracked caps the number of distinct metadata values tracked while walking a
+	// bucket, 0 is unbounded
+	metadataMaxTracked int
+	// ageTiers are the age ranges objects are counted in. Empty disables the age collector
+	ageTiers []ageTier
+	// hashPresence enables counting objects without a hash
+	hashPresence bool
+	// sizeStddev enables exporting the standard deviation of object sizes
+	sizeStddev bool
+	// objectFilters are the remote's named object filters
+	objectFilters []objectFilter
+	// storageClasses are the storage classes objects are counted in. Empty disables the collector
+	storageClasses []string
+	// dirCount enables exporting the number of directories per bucket
+	dirCount bool
+	// countPseudoDirs counts the directories of backends that can't have empty directories, which
+	// only exist as the common prefixes of object names
+	countPseudoDirs bool
+	// unknownSizePolicy controls how objects with an unknown size affect walk-based totals
+	unknownSizePolicy unknownSizePolicy
+	// adaptiveTimeoutMultiplier bounds each bucket count by this multiple of the bucket's recent
+	// mean count duration, unless the bucket has a configured timeout. 0 disables it
+	adaptiveTimeoutMultiplier float64
+	// adaptiveTimeoutMin is the floor of adaptive timeouts
+	adaptiveTimeoutMin time.Duration
+	// adaptiveTimeoutMax is the ceiling of adaptive timeouts, also used before a bucket's first count
+	adaptiveTimeoutMax time.Duration
+	// stallTimeout lets bucket counts run past their timeout while they list objects, aborting them
+	// once they list none for this long. 0 aborts them at their timeout
+	stallTimeout time.Duration
+	// stallTimeoutMax bounds how long counts extended by stallTimeout can run in total
+	stallTimeoutMax time.Duration
+	// rateLimitCooldown is how long scrapes of a remote are first suppressed after it rate limited
+	// the exporter, 0 disables cooldowns
+	rateLimitCooldown time.Duration
+	// rateLimitCooldownMax caps cooldowns, which double with every consecutive rate limited scrape
+	rateLimitCooldownMax time.Duration
+	// minAge leaves objects modified more recently out of counts, e.g. uploads in progress. 0
+	// counts every object
+	minAge time.Duration
+	// clockSkew enables comparing each remote endpoint's clock with the exporter's
+	clockSkew bool
+}
+
+// walkEnabled reports whether any collector needing a walk over every object is enabled
+func (o *options) walkEnabled() bool {
+	return o.metadataKey != "" || len(o.ageTiers) > 0 || o.hashPresence || o.sizeStddev || len(o.objectFilters) > 0 || o.dirCount || len(o.storageClasses) > 0
 }
 
 // ListDir lists the top-level directories (buckets) of the given Fs
@@ -55,108 +455,1023 @@ func ListDir(ctx context.Context, f fs.Fs) (fs.DirEntries, error) {
 	return dirs, err
 }
 
+// countBucket calls operations.Count() on the bucket, retrying up to opts.retries times on failure.
+// It returns the file count and total size along with the number of objects excluded for being too
+// recent, the number of retries it took and the time spent on the failed attempts that were retried
+func countBucket(ctx context.Context, bucketFs fs.Fs, opts *options, progress func(files, size int64), contextLogger *logrus.Entry) (files, size, excluded int64, retries int, retryTime time.Duration, err error) {
+	for {
+		start := opts.clock.Now()
+		if progress != nil && opts.progressInterval > 0 || opts.minAge > 0 || opts.stallTimeout > 0 {
+			files, size, excluded, err = countObjects(ctx, bucketFs, opts, progress)
+		} else {
+			// operations.Count returns file count, total size in bytes and the number of objects
+			// with an unknown size. We ignore the unknown size count
+			files, size, _, err = operations.Count(ctx, bucketFs)
+		}
+		if err == nil || retries >= opts.retries || ctx.Err() != nil {
+			return files, size, excluded, retries, retryTime, err
+		}
+		retryTime += opts.clock.Since(start)
+		retries++
+		contextLogger.WithError(err).WithField("retry", retries).Warn("retrying bucket count")
+	}
+}
+
+// addRetryTime adds time lost to retries to the remote's total for the current scrape
+func addRetryTime(remote string, retryTime time.Duration) {
+	state.remote(remote, func(r *remoteState) {
+		r.retryTime += retryTime
+	})
+}
+
+// dedupeBuckets returns the buckets without duplicates, in order, and the duplicate entries removed
+func dedupeBuckets(buckets []string) (unique, duplicates []string) {
+	seen := map[string]bool{}
+	for _, bucket := range buckets {
+		if seen[bucket] {
+			duplicates = append(duplicates, bucket)
+			continue
+		}
+		seen[bucket] = true
+		unique = append(unique, bucket)
+	}
+	return unique, duplicates
+}
+
 // updateRemoteBuckets lists the top-level directories (buckets) in the given remote using ListDir(),
 // then for each bucket, it calls operations.Count() to get the file count and total size
-func updateRemoteBuckets(ctx context.Context, remote string) {
-	// Create a new Fs for the remote
-	f, err := fs.NewFs(ctx, remote)
+//
+// It returns whether the remote was scraped without errors
+func updateRemoteBuckets(ctx context.Context, remoteCfg remoteConfig, opts *options) (ok bool) {
+	remote := remoteCfg.Name
+	err := opts.limiter.acquire(ctx, opts.clock)
+	// The scrape is no longer pending once it either starts or gives up waiting
+	scrapeBacklog.Dec()
 	if err != nil {
-		logrus.WithField("remote", remote).WithError(err).Error("failed creating Fs for remote")
-		return
+		logrus.WithField("remote", remote).WithError(err).Error("failed waiting to scrape remote")
+		recordRemoteError(remote, "wait", err)
+		return false
+	}
+	defer opts.limiter.release()
+	ctx, cancel := context.WithTimeout(ctx, remoteCfg.timeout(opts))
+	defer cancel()
+	ctx = withDNSTrace(ctx, remote, opts.clock)
+	ctx = remoteCfg.withFastList(ctx, opts)
+	ctx, done := withScrapeStats(ctx, remote, opts.clock)
+	defer done()
+	defer func() {
+		timedOut := errors.Is(ctx.Err(), context.DeadlineExceeded)
+		state.remote(remote, func(r *remoteState) {
+			r.lastTimedOut = timedOut
+		})
+	}()
+
+	// Values approaching 1 mean the remote can't be scraped reliably within the update period
+	start := opts.clock.Now()
+	defer func() {
+		remoteScrapeDutyCycle.WithLabelValues(remote).Set(opts.clock.Since(start).Seconds() / remoteCfg.updatePeriod(opts).Seconds())
+	}()
+	// Time lost to retries is summed over the buckets and paths counted during this scrape
+	state.remote(remote, func(r *remoteState) {
+		r.retryTime = 0
+	})
+	defer state.remote(remote, func(r *remoteState) {
+		remoteRetryTime.WithLabelValues(remote).Set(r.retryTime.Seconds())
+	})
+	if opts.clockSkew {
+		updateClockSkew(ctx, remoteCfg, opts)
 	}
 
-	// List top-level directories (buckets). The empty string ("") lists the root
-	dirs, err := ListDir(ctx, f)
+	// Create a new Fs for the remote, rooted at the discovery prefix if it has one
+	f, err := remoteCfg.newFs(ctx, remoteCfg.DiscoveryPrefix)
 	if err != nil {
+		logrus.WithField("remote", remote).WithError(err).Error("failed creating Fs for remote")
+		recordRemoteError(remote, "new_fs", err)
+		return false
+	}
+	updateFeatureMetrics(remote, f)
+
+	// Count the explicitly configured paths whether or not the buckets can be listed
+	defer func() {
+		if !updateRemotePaths(ctx, remoteCfg, opts) {
+			ok = false
+		}
+	}()
+
+	// List top-level directories (buckets). The empty string ("") lists the root. Flat remotes
+	// without buckets are instead counted as a whole
+	var dirs fs.DirEntries
+	if !opts.countRoot {
+		var pages atomic.Int64
+		dirs, err = ListDir(withRequestCount(withListOperations(ctx, remote), &pages), f)
+		remoteDiscoveryPages.WithLabelValues(remote).Set(float64(pages.Load()))
+	}
+	bucketPaths := []string{}
+	partial := false
+	switch {
+	case opts.countRoot:
+		bucketPaths = []string{""}
+	case err == nil || (opts.proceedOnPartialListing && len(dirs) > 0):
+		if err != nil {
+			// Count what was discovered before the listing failed rather than discarding it, still
+			// failing the remote's scrape
+			logrus.WithField("remote", remote).WithError(err).Warn("listing remote failed partway, counting the buckets discovered so far")
+			recordRemoteError(remote, "list", err)
+			partial = true
+		}
+		for _, d := range dirs {
+			// Get the bucket name from the directory entry
+			bucketPaths = append(bucketPaths, d.Remote())
+		}
+		// A misbehaving backend returning a bucket twice would otherwise have it counted twice
+		var duplicates []string
+		bucketPaths, duplicates = dedupeBuckets(bucketPaths)
+		if len(duplicates) > 0 {
+			logrus.WithFields(logrus.Fields{
+				"remote":  remote,
+				"buckets": duplicates,
+			}).Warn("listing remote returned duplicate buckets")
+		}
+		remoteDuplicateBuckets.WithLabelValues(remote).Set(float64(len(duplicates)))
+		discovered := len(bucketPaths)
+		bucketPaths = remoteCfg.filterBuckets(bucketPaths)
+		remoteBucketsFiltered.WithLabelValues(remote).Set(float64(discovered - len(bucketPaths)))
+	case len(remoteCfg.Buckets) > 0:
+		// Scoped credentials may be able to read named buckets without being able to list the root
+		logrus.WithField("remote", remote).WithError(err).Warn("failed listing directories for remote, counting configured buckets instead")
+		bucketPaths = remoteCfg.Buckets
+	default:
 		logrus.WithField("remote", remote).WithError(err).Error("failed listing directories for remote")
-		return
+		recordRemoteError(remote, "list", err)
+		return false
+	}
+
+	if opts.maxBuckets > 0 && len(bucketPaths) > opts.maxBuckets {
+		err := fmt.Errorf("remote has %d buckets, more than the limit of %d", len(bucketPaths), opts.maxBuckets)
+		logrus.WithField("remote", remote).WithError(err).Error("refusing to scrape remote")
+		recordRemoteError(remote, "max_buckets", err)
+		return false
+	}
+	// Remotes with unexpectedly many buckets are estimated instead of counted so they degrade
+	// gracefully rather than timing out
+	if opts.sampleAboveBuckets > 0 && len(bucketPaths) > opts.sampleAboveBuckets && !remoteCfg.Sample {
+		logrus.WithFields(logrus.Fields{
+			"remote":  remote,
+			"buckets": len(bucketPaths),
+		}).Warn("remote has too many buckets to count, sampling instead")
+		remoteCfg.Sample = true
 	}
+	sampling := 0.0
+	if remoteCfg.Sample {
+		sampling = 1
+	}
+	remoteSamplingActive.WithLabelValues(remote).Set(sampling)
 
-	for _, d := range dirs {
-		// Get the bucket name from the directory entry
-		bucketName := d.Remote()
-		// Construct the bucket remote. For example, "b2:" + "mybucket" becomes "b2:mybucket"
-		bucketRemote := remote + bucketName
-		contextLogger := logrus.WithField("bucket", bucketRemote)
+	partialValue := 0.0
+	if partial {
+		partialValue = 1
+	}
+	remoteListingPartial.WithLabelValues(remote).Set(partialValue)
 
-		// Create a new Fs for the bucket
-		bucketFs, err := fs.NewFs(ctx, bucketRemote)
+	bucketNames := bucketLabels(ctx, remoteCfg, bucketPaths, opts)
+	if opts.staleSeriesGraceCycles > 0 && !opts.countRoot && err == nil {
+		pruneStaleBuckets(remote, bucketNames, opts)
+	}
+	ok = !partial
+	slowestBucket, slowest := "", time.Duration(-1)
+	if opts.stalestFirst {
+		stalestBucketsFirst(remote, bucketPaths, bucketNames)
+	}
+	checkers := bucketCheckers(remote, bucketNames, opts)
+	backendLimiter := opts.backendLimiter(remote)
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		peak  peakTracker
+		slots = make(chan struct{}, max(opts.bucketConcurrency, 1))
+	)
+	for i, bucketPath := range bucketPaths {
+		bucketName := bucketNames[i]
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			if err := backendLimiter.acquire(ctx, opts.clock); err != nil {
+				logrus.WithFields(logrus.Fields{"remote": remote, "bucket": bucketName}).WithError(err).Error("failed waiting to count bucket")
+				recordRemoteError(remote, "wait", err)
+				mu.Lock()
+				defer mu.Unlock()
+				ok = false
+				return
+			}
+			defer backendLimiter.release()
+			peak.start()
+			defer peak.done()
+			bucketStart := opts.clock.Now()
+			bucketOK := updateBucket(withCheckers(ctx, checkers[bucketName]), remoteCfg, bucketPath, bucketName, opts)
+			elapsed := opts.clock.Since(bucketStart)
+			if bucketOK {
+				state.bucket(remote, bucketName, func(b *bucketState) {
+					b.lastSuccessTime = opts.clock.Now()
+				})
+				bucketScrapeFailed.WithLabelValues(remote, bucketName).Set(0)
+			} else {
+				bucketScrapeFailed.WithLabelValues(remote, bucketName).Set(1)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !bucketOK {
+				ok = false
+			}
+			if elapsed > slowest {
+				slowestBucket, slowest = bucketName, elapsed
+			}
+		}()
+	}
+	wg.Wait()
+	remoteEffectiveConcurrency.WithLabelValues(remote).Set(float64(peak.peak))
+	if remoteCfg.SummaryOnly {
+		updateRemoteSummary(remote, bucketNames)
+	} else {
+		deleteRemoteSummary(remote)
+	}
+	remoteSlowestBucket.DeletePartialMatch(prometheus.Labels{"remote": remote})
+	if slowest >= 0 {
+		remoteSlowestBucket.WithLabelValues(remote, slowestBucket).Set(slowest.Seconds())
+	}
+	return ok
+}
+
+// bucketLabels returns the bucket label of each of the remote's bucket paths. That's the path
+// itself, except for the root counted with -count-root and for names decrypted with the remote's
+// crypt remote
+func bucketLabels(ctx context.Context, remoteCfg remoteConfig, bucketPaths []string, opts *options) []string {
+	if opts.countRoot {
+		return []string{opts.rootBucketName}
+	}
+	if remoteCfg.DecryptNamesWith == "" {
+		return bucketPaths
+	}
+	decrypter, err := newNameDecrypter(ctx, remoteCfg.DecryptNamesWith)
+	if err != nil {
+		// Fall back to the encrypted names rather than failing the scrape
+		logrus.WithField("remote", remoteCfg.Name).WithError(err).Error("failed creating crypt remote to decrypt bucket names")
+		recordRemoteError(remoteCfg.Name, "decrypt", err)
+		return bucketPaths
+	}
+	labels := make([]string, len(bucketPaths))
+	for i, bucketPath := range bucketPaths {
+		labels[i] = decryptPath(decrypter, bucketPath)
+	}
+	return labels
+}
+
+// updateBucket counts the bucket at bucketPath and runs its enabled collectors, updating its
+// metrics labelled with bucketName. It returns whether the bucket was counted
+func updateBucket(ctx context.Context, remoteCfg remoteConfig, bucketPath, bucketName string, opts *options) bool {
+	remote := remoteCfg.Name
+	// Construct the bucket remote. For example, "b2:" + "mybucket" becomes "b2:mybucket"
+	bucketRemote := remote + bucketPath
+	contextLogger := logrus.WithField("bucket", bucketRemote)
+
+	// Giant buckets can be given their own, shorter, deadline so they can't starve the rest of the
+	// remote's buckets
+	if timeout, ok := remoteCfg.BucketTimeouts[bucketName]; ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Create a new Fs for the bucket
+	bucketFs, err := remoteCfg.newFs(ctx, bucketPath)
+	if err != nil {
+		contextLogger.WithError(err).Error("failed creating Fs for bucket")
+		recordRemoteError(remote, "new_fs", err)
+		return false
+	}
+
+	if remoteCfg.Canary.enabled(bucketName) {
+		defer updateCanaryMetrics(ctx, remote, bucketName, bucketFs, remoteCfg.Canary.objectPath(), opts, contextLogger)
+	}
+
+	if opts.multipartUploads {
+		updateMultipartMetrics(ctx, remote, bucketName, bucketFs, contextLogger)
+	}
+
+	// Buckets too big to count exactly are estimated from a sample of their prefixes instead
+	if remoteCfg.Sample {
+		estimate, err := estimateBucket(withListOperations(ctx, remote), bucketFs, opts.samplePrefixes)
 		if err != nil {
-			contextLogger.WithError(err).Error("failed creating Fs for bucket")
-			continue
+			contextLogger.WithError(err).Error("failed estimating bucket")
+			recordRemoteError(remote, "estimate", err)
+			return false
 		}
+		bucketEstimatedSize.WithLabelValues(remote, bucketName).Set(estimate.size)
+		bucketEstimatedFileCount.WithLabelValues(remote, bucketName).Set(estimate.files)
+		bucketEstimatedSizeRelativeError.WithLabelValues(remote, bucketName).Set(estimate.relativeError)
+		contextLogger.WithFields(logrus.Fields{
+			"size":           estimate.size,
+			"count":          estimate.files,
+			"relative_error": estimate.relativeError,
+		}).Info("updated estimated bucket metrics")
+		return true
+	}
 
-		// operations.Count returns file count, directory count, and total size in bytes
-		// We ignore the directory count
-		files, size, _, err := operations.Count(ctx, bucketFs)
+	// Without a configured bucket timeout, the count can be bounded by a timeout adapted to how long
+	// the bucket usually takes, catching hangs without killing counts that are just slow
+	countCtx := ctx
+	adaptive := false
+	if _, ok := remoteCfg.BucketTimeouts[bucketName]; !ok && opts.adaptiveTimeoutMultiplier > 0 {
+		timeout := adaptiveTimeout(remote, bucketName, opts)
+		contextLogger.WithField("timeout", timeout).Debug("using adaptive count timeout")
+		var cancel context.CancelFunc
+		countCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+		adaptive = true
+	}
+	// Counts still making progress can run past the deadlines above, until they stall
+	listCtx := countCtx
+	if opts.stallTimeout > 0 {
+		var cancel context.CancelFunc
+		listCtx, cancel = withStallTimeout(countCtx, opts, contextLogger)
+		defer cancel()
+	}
+	countStart := opts.clock.Now()
+	files, size, excluded, retries, retryTime, err := countBucket(withListOperations(listCtx, remote), bucketFs, opts, publishBucketProgress(remote, bucketName), contextLogger)
+	addRetryTime(remote, retryTime)
+	bucketRetries.WithLabelValues(remote, bucketName).Add(float64(retries))
+	bucketRetriesLastScrape.WithLabelValues(remote, bucketName).Set(float64(retries))
+	if err != nil {
+		cause := context.Cause(listCtx)
+		switch {
+		case errors.Is(cause, errCountStalled):
+			bucketCountStalled.WithLabelValues(remote, bucketName).Inc()
+			err = cause
+		case errors.Is(cause, errCountTimeoutMax):
+			// The count outlived its adaptive timeout, so that's not what stopped it
+			err = cause
+		case adaptive && isAdaptiveTimeout(ctx, listCtx):
+			// Checked against the context the count ran with, as a count extended past its
+			// adaptive deadline can still fail for another reason once that deadline has passed
+			bucketAdaptiveTimeouts.WithLabelValues(remote, bucketName).Inc()
+		}
+		if opts.progressInterval > 0 {
+			restoreBucketCounts(remote, bucketName)
+		}
+		contextLogger.WithError(err).Error("failed counting bucket")
+		recordRemoteError(remote, "count", err)
+		return false
+	}
+
+	// A sudden drop may be an accidental mass deletion, which can be kept from overwriting the
+	// last good counts
+	if threshold := remoteCfg.regressionThreshold(opts); threshold > 0 && checkRegression(remote, bucketName, files, size, threshold) {
+		contextLogger.WithFields(logrus.Fields{
+			"size":  size,
+			"count": files,
+		}).Error("bucket count regressed")
+		if opts.failOnRegression {
+			if opts.progressInterval > 0 {
+				restoreBucketCounts(remote, bucketName)
+			}
+			recordRemoteError(remote, "regression", nil)
+			return false
+		}
+	}
+	state.bucket(remote, bucketName, func(b *bucketState) {
+		updateFileCountGrowth(remote, bucketName, b, files, opts.clock.Now())
+		b.lastSize, b.lastFiles, b.sized = size, files, true
+	})
+	opts.baseline.compare(remote, bucketName, size)
+	recordCountDuration(remote, bucketName, opts.clock.Since(countStart))
+
+	// Update Prometheus metrics
+	bucketSize.WithLabelValues(remote, bucketName).Set(float64(size))
+	bucketFileCount.WithLabelValues(remote, bucketName).Set(float64(files))
+	if opts.minAge > 0 {
+		bucketRecentObjectsExcluded.WithLabelValues(remote, bucketName).Set(float64(excluded))
+	} else {
+		bucketRecentObjectsExcluded.DeleteLabelValues(remote, bucketName)
+	}
+	contextLogger.WithFields(logrus.Fields{
+		"size":  size,
+		"count": files,
+	}).Info("updated bucket metrics")
+	opts.events.publish(bucketEvent{
+		Remote:    remote,
+		Bucket:    bucketName,
+		Size:      size,
+		Count:     files,
+		Timestamp: opts.clock.Now(),
+	})
+	if opts.anomalyWindow > 0 {
+		updateFileCountAnomaly(remote, bucketName, files, opts)
+	}
+
+	if opts.walkEnabled() {
+		result, err := walkBucket(withListOperations(ctx, remote), bucketFs, opts)
 		if err != nil {
-			contextLogger.WithError(err).Error("failed counting bucket")
-			continue
+			contextLogger.WithError(err).Error("failed walking bucket objects")
+			recordRemoteError(remote, "walk", err)
+		} else {
+			updateWalkMetrics(remote, bucketName, result, opts)
+			bucketSizeDiscrepancy.WithLabelValues(remote, bucketName).Set(float64(size - result.size))
+			// Both list the same bucket, so a difference points at a listing inconsistency
+			if result.objects != files {
+				contextLogger.WithFields(logrus.Fields{
+					"count":   files,
+					"counted": result.objects,
+				}).Warn("walk found a different number of objects than the count")
+			}
 		}
+	}
+	return true
+}
 
-		// Update Prometheus metrics
-		bucketSize.WithLabelValues(remote, bucketName).Set(float64(size))
-		bucketFileCount.WithLabelValues(remote, bucketName).Set(float64(files))
+// updateRemotePaths counts each of the remote's configured paths via its own Fs, labelling the
+// metrics with the path's configured name. It returns whether every path was counted
+func updateRemotePaths(ctx context.Context, remoteCfg remoteConfig, opts *options) bool {
+	remote := remoteCfg.Name
+	backendLimiter := opts.backendLimiter(remote)
+	ok := true
+	for _, path := range remoteCfg.Paths {
+		contextLogger := logrus.WithFields(logrus.Fields{
+			"remote": remote,
+			"path":   path.Path,
+			"name":   path.Name,
+		})
+		pathFs, err := remoteCfg.newFs(ctx, path.Path)
+		if err != nil {
+			contextLogger.WithError(err).Error("failed creating Fs for path")
+			recordRemoteError(remote, "new_fs", err)
+			ok = false
+			continue
+		}
+		if err := backendLimiter.acquire(ctx, opts.clock); err != nil {
+			contextLogger.WithError(err).Error("failed waiting to count path")
+			recordRemoteError(remote, "wait", err)
+			ok = false
+			continue
+		}
+		files, size, excluded, _, retryTime, err := countBucket(withListOperations(ctx, remote), pathFs, opts, nil, contextLogger)
+		backendLimiter.release()
+		addRetryTime(remote, retryTime)
+		if err != nil {
+			contextLogger.WithError(err).Error("failed counting path")
+			recordRemoteError(remote, "count", err)
+			ok = false
+			continue
+		}
+		pathSize.WithLabelValues(remote, path.Name).Set(float64(size))
+		pathFileCount.WithLabelValues(remote, path.Name).Set(float64(files))
+		if opts.minAge > 0 {
+			pathRecentObjectsExcluded.WithLabelValues(remote, path.Name).Set(float64(excluded))
+		} else {
+			pathRecentObjectsExcluded.DeleteLabelValues(remote, path.Name)
+		}
 		contextLogger.WithFields(logrus.Fields{
 			"size":  size,
 			"count": files,
-		}).Info("updated bucket metrics")
+		}).Info("updated path metrics")
+	}
+	return ok
+}
+
+// updateCanaryMetrics runs the canary check in the bucket and records its outcome
+func updateCanaryMetrics(ctx context.Context, remote, bucketName string, bucketFs fs.Fs, path string, opts *options, contextLogger *logrus.Entry) {
+	start := opts.clock.Now()
+	err := errReadOnly
+	if !opts.readOnly {
+		err = runCanary(ctx, bucketFs, path, opts.clock)
+	}
+	if err != nil {
+		contextLogger.WithError(err).Error("canary check failed")
+		recordRemoteError(remote, "canary", err)
+		bucketCanarySuccess.WithLabelValues(remote, bucketName).Set(0)
+		return
+	}
+	bucketCanarySuccess.WithLabelValues(remote, bucketName).Set(1)
+	bucketCanaryLatency.WithLabelValues(remote, bucketName).Set(opts.clock.Since(start).Seconds())
+}
+
+// updateWalkMetrics replaces the bucket's walk-based metrics with the results of the latest walk
+func updateWalkMetrics(remote, bucketName string, result *bucketWalk, opts *options) {
+	bucketCountedObjects.WithLabelValues(remote, bucketName).Set(float64(result.objects))
+	labels := prometheus.Labels{"remote": remote, "bucket": bucketName}
+	bucketSizeByMetadata.DeletePartialMatch(labels)
+	bucketFileCountByMetadata.DeletePartialMatch(labels)
+	for value, group := range result.byMetadata {
+		bucketSizeByMetadata.WithLabelValues(remote, bucketName, value).Set(float64(group.size))
+		bucketFileCountByMetadata.WithLabelValues(remote, bucketName, value).Set(float64(group.count))
+	}
+	if opts.metadataKey != "" {
+		bucketSizeByMetadataError.WithLabelValues(remote, bucketName).Set(float64(result.byMetadataMaxError))
+	} else {
+		bucketSizeByMetadataError.DeleteLabelValues(remote, bucketName)
+	}
+	if len(opts.ageTiers) > 0 {
+		for i, tier := range opts.ageTiers {
+			bucketObjectsByAge.WithLabelValues(remote, bucketName, tier.label).Set(float64(result.byAge[i].count))
+			bucketBytesByAge.WithLabelValues(remote, bucketName, tier.label).Set(float64(result.byAge[i].size))
+		}
+		bucketObjectsNoModTime.WithLabelValues(remote, bucketName).Set(float64(result.noModTime))
+	}
+	if opts.hashPresence {
+		bucketObjectsWithoutHash.WithLabelValues(remote, bucketName).Set(float64(result.noHash))
+	}
+	if opts.sizeStddev {
+		bucketObjectSizeStddev.WithLabelValues(remote, bucketName).Set(result.sizes.stddev())
+	}
+	if result.dirsCounted {
+		bucketDirCount.WithLabelValues(remote, bucketName).Set(float64(result.dirs))
+	} else {
+		bucketDirCount.DeleteLabelValues(remote, bucketName)
+	}
+	labels = prometheus.Labels{"remote": remote, "bucket": bucketName}
+	bucketSizeByStorageClass.DeletePartialMatch(labels)
+	bucketFileCountByStorageClass.DeletePartialMatch(labels)
+	if result.storageClassKnown {
+		for i, class := range opts.storageClasses {
+			bucketSizeByStorageClass.WithLabelValues(remote, bucketName, class).Set(float64(result.byStorageClass[i].size))
+			bucketFileCountByStorageClass.WithLabelValues(remote, bucketName, class).Set(float64(result.byStorageClass[i].count))
+		}
+	}
+	for i, objectFilter := range opts.objectFilters {
+		bucketSizeByFilter.WithLabelValues(remote, bucketName, objectFilter.name).Set(float64(result.byFilter[i].size))
+		bucketFileCountByFilter.WithLabelValues(remote, bucketName, objectFilter.name).Set(float64(result.byFilter[i].count))
 	}
 }
 
-// updateRemotes runs updateRemoteBuckets on each remote in a goroutine
-func updateRemotes(ctx context.Context, remotes []string) {
+// updateRemotes runs updateRemoteBuckets on each remote in a goroutine and waits for them to finish.
+// When enabled, quota is fetched for every remote first, in parallel, so it populates quickly.
+// With opts.sequential the remotes are instead processed one at a time in order
+func updateRemotes(ctx context.Context, configured []remoteConfig, opts *options) {
+	remotes := skipMissingRemotes(configured, opts)
+	due := dueRemotes(remotes, opts)
+	if opts.stalestFirst {
+		due = stalestRemotesFirst(due)
+	}
+	// Every due remote is pending until updateRemoteBuckets gets to start scraping it
+	scrapeBacklog.Add(float64(len(due)))
+	var wg sync.WaitGroup
+	run := func(fn func()) {
+		if opts.sequential {
+			fn()
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+	for _, remote := range due {
+		if remote.options(opts).about {
+			run(func() {
+				updateRemoteAbout(ctx, remote, remote.options(opts))
+			})
+		}
+	}
+	for _, remote := range due {
+		run(func() {
+			state.remote(remote.Name, func(r *remoteState) {
+				r.lastStart = opts.clock.Now()
+				if r.firstAttempt.IsZero() {
+					r.firstAttempt = r.lastStart
+				}
+			})
+			ok := updateRemoteBuckets(ctx, remote, remote.options(opts))
+			state.remote(remote.Name, func(r *remoteState) {
+				r.lastSuccess = ok
+				updateCooldown(remote.Name, r, ok, opts)
+				if ok {
+					r.lastSuccessTime = opts.clock.Now()
+					r.failingSince = time.Time{}
+					remoteFailingSince.DeleteLabelValues(remote.Name)
+				} else {
+					if r.failingSince.IsZero() {
+						r.failingSince = r.lastStart
+					}
+					remoteFailingSince.WithLabelValues(remote.Name).Set(float64(r.failingSince.Unix()))
+				}
+				r.everSucceeded = r.everSucceeded || ok
+				r.outcomes = append(r.outcomes, ok)
+				if len(r.outcomes) > opts.successWindow {
+					r.outcomes = r.outcomes[len(r.outcomes)-opts.successWindow:]
+				}
+				successes := 0
+				for _, outcome := range r.outcomes {
+					if outcome {
+						successes++
+					}
+				}
+				remoteSuccessRatio.WithLabelValues(remote.Name).Set(float64(successes) / float64(len(r.outcomes)))
+				// A remote that never succeeded needs fixing, one that went stale may just be slow
+				neverSucceeded := 1.0
+				if r.everSucceeded {
+					neverSucceeded = 0
+				}
+				remoteNeverSucceeded.WithLabelValues(remote.Name).Set(neverSucceeded)
+				remoteFirstAttemptAge.WithLabelValues(remote.Name).Set(opts.clock.Since(r.firstAttempt).Seconds())
+			})
+		})
+	}
+	wg.Wait()
+
+	healthy := 0
 	for _, remote := range remotes {
-		go updateRemoteBuckets(ctx, remote)
+		state.remote(remote.Name, func(r *remoteState) {
+			if r.lastSuccess {
+				healthy++
+			}
+		})
 	}
+	// Only remotes scraped this cycle can have timed out in it, the others keep their last outcome
+	timedOut := 0
+	for _, remote := range due {
+		state.remote(remote.Name, func(r *remoteState) {
+			if r.lastTimedOut {
+				timedOut++
+			}
+		})
+	}
+	scrapeCycleTimeouts.Set(float64(timedOut))
+	remotesConfigured.Set(float64(len(configured)))
+	remotesHealthy.Set(float64(healthy))
+}
+
+// untilNextBoundary returns how long it is from now until the next multiple of period since the
+// zero time, e.g. the top of the next hour for an hourly period
+func untilNextBoundary(now time.Time, period time.Duration) time.Duration {
+	return now.Truncate(period).Add(period).Sub(now)
+}
+
+// handleInstrumented registers handler on mux for pattern, counting its requests by status code.
+// The path label is fixed per pattern to keep its cardinality bounded
+func handleInstrumented(mux *http.ServeMux, pattern, path string, handler http.Handler) {
+	counter := httpRequests.MustCurryWith(prometheus.Labels{"path": path})
+	mux.Handle(pattern, promhttp.InstrumentHandlerCounter(counter, handler))
 }
 
 func main() {
 	// Parse command-line arguments
-	remotesFlag := flag.String("remote", "", "comma separated list of remotes to monitor (REQUIRED)")
+	remotesFlag := flag.String("remote", "", "comma separated list of remotes to monitor (REQUIRED unless -config-dir is set)")
+	mountPathFlag := flag.String("mount-path", "", "comma separated list of local paths, such as existing rclone mounts, to monitor like remotes, counting their top-level directories as buckets")
+	allRemotesFlag := flag.Bool("all-remotes", false, "monitor every remote defined in the rclone config, except those wrapping other remotes (alias, crypt, union...) and local or memory remotes")
+	allRemotesRegexFlag := flag.String("all-remotes-regex", "", "with -all-remotes, only monitor the remotes whose name, e.g. \"b2:\", matches this regex")
+	configDirFlag := flag.String("config-dir", "", "directory of YAML fragments defining remotes to monitor, merged with -remote")
+	reloadOnChangeFlag := flag.Bool("reload-on-change", false, "watch -config-dir and reload the remotes when the fragments change")
 	updatePeriodFlag := flag.Int("update-period", 60, "update period in minutes")
-	listenAddrFlag := flag.String("listen", ":8080", "address to listen on for serving metrics")
-	remoteTimeoutFlag := flag.Int("remote-timeout", 30, "timeout in seconds for calls to the remotes")
+	alignScheduleFlag := flag.Bool("align-schedule", false, "align periodic scrapes to boundaries of the update period (e.g. the top of the hour) instead of the start time")
+	listenAddrFlag := flag.String("listen", ":8080", "address to listen on for serving metrics, or unix:/path/to/socket for a Unix domain socket")
+	remoteTimeoutFlag := flag.Int("remote-timeout", 30, "timeout in seconds for scraping each remote, unless overridden in -config-dir")
+	concurrencyFlag := flag.Int("concurrency", 0, "max number of remotes scraped concurrently, 0 for no limit")
+	backendConcurrencyFlag := flag.String("backend-concurrency", "", "comma separated backend=limit pairs, e.g. s3=16,sftp=1, bounding the buckets and paths counted concurrently across all remotes of each backend")
+	bucketConcurrencyFlag := flag.Int("bucket-concurrency", 1, "number of each remote's buckets counted concurrently")
+	sequentialFlag := flag.Bool("sequential", false, "scrape remotes one at a time in order instead of concurrently")
+	aboutFlag := flag.Bool("about", false, "export quota information for remotes whose backend supports About")
+	countRootFlag := flag.Bool("count-root", false, "count each remote's root as a single bucket instead of its top-level directories, for flat remotes such as SFTP or WebDAV")
+	rootBucketNameFlag := flag.String("root-bucket-name", "root", "bucket label of the remote's root with -count-root")
+	onMissingRemoteFlag := flag.String("on-missing-remote", string(missingRemoteRetry), "what to do with remotes not defined in the rclone config: fail (at startup), skip (removing their metrics) or retry")
+	listingConcurrencyFlag := flag.Int("listing-concurrency", 0, "concurrent directory listings used to count a remote's largest bucket, scaled down for smaller buckets by their previous size, 0 for rclone's default (unused by fast list, which lists in one stream)")
+	regressionThresholdFlag := flag.Float64("regression-threshold", 0, "percentage a bucket's file count or size must drop by since the previous scrape to set rclone_bucket_regression_detected, 0 to disable")
+	failOnRegressionFlag := flag.Bool("fail-on-regression", false, "fail the scrape of a bucket whose counts regressed, keeping its last good counts")
+	fastListFlag := flag.Bool("fast-list", true, "list buckets recursively in one go on backends that support it (rclone's --fast-list), unless overridden per remote in -config-dir")
+	successWindowFlag := flag.Int("success-window", 10, "number of recent scrapes rclone_remote_success_ratio is computed over")
+	retriesFlag := flag.Int("retries", 0, "number of times to retry counting a bucket after a failure")
+	socksProxyFlag := flag.String("socks-proxy", "", "SOCKS5 proxy to connect to the remotes through, as host:port or socks5://[user:pass@]host:port")
+	staleSeriesGraceCyclesFlag := flag.Int("stale-series-grace-cycles", 0, "delete the series of buckets missing from this many consecutive successful listings of their remote, 0 to keep them")
+	progressIntervalFlag := flag.Int("progress-interval", 0, "publish the running size and count of buckets being counted every this many seconds, so the metrics show partial counts while counting, 0 to only publish complete counts")
+	stalestFirstFlag := flag.Bool("stalest-first", false, "scrape the remotes and buckets whose last successful scrape is oldest first, to keep data fresh when scrapes are limited by -concurrency, -bucket-concurrency or timeouts")
+	readOnlyFlag := flag.Bool("read-only", true, "refuse any operation modifying the remotes, set to false to allow collectors that write such as the canary")
+	caCertFlag := flag.String("ca-cert", "", "PEM bundle of CAs to verify the remotes' TLS certificates against instead of the system's, e.g. for private S3-compatible endpoints")
+	connectTimeoutFlag := flag.Int("connect-timeout", 60, "timeout in seconds for establishing a connection to a remote, unless overridden in -config-dir. rclone applies it to the TLS handshake too")
+	anomalyWindowFlag := flag.Int("anomaly-window", 0, "number of previous scrapes forming the baseline for rclone_bucket_file_count_anomaly, 0 to disable")
+	anomalyThresholdFlag := flag.Float64("anomaly-threshold", 50, "percentage a bucket's file count must deviate from its baseline to be flagged as an anomaly")
+	multipartUploadsFlag := flag.Bool("multipart-uploads", false, "export the number of incomplete multipart uploads per bucket (S3 only)")
+	samplePrefixesFlag := flag.Int("sample-prefixes", 20, "number of prefixes fully counted per bucket when estimating the size of remotes with sampling enabled (min 2)")
+	proceedOnPartialListingFlag := flag.Bool("proceed-on-partial-listing", false, "count the buckets discovered before listing a remote failed partway instead of none, still marking the scrape failed")
+	sampleAboveBucketsFlag := flag.Int("sample-above-buckets", 0, "estimate the buckets of remotes with more than this many buckets from samples instead of counting them, 0 to disable")
+	maxBucketsFlag := flag.Int("max-buckets", 0, "fail the scrape of remotes with more than this many buckets, 0 to disable")
+	bucketLabelRegexFlag := flag.String("bucket-label-regex", "", "regex with one named capture group deriving an extra label from bucket names, e.g. ^(?P<env>prod|staging)-")
+	statsdAddrFlag := flag.String("statsd-addr", "", "host:port of a StatsD server to also send the size and count metrics to after each update")
+	statsdTagFormatFlag := flag.String("statsd-tag-format", string(statsdTagsDogStatsD), "how labels are sent to StatsD: dogstatsd, influx or none (appended to the name)")
+	metricsMaxBytesFlag := flag.Int("metrics-max-bytes", 0, "max size of the /metrics response, leaving out whole metric families beyond it and setting rclone_exporter_metrics_truncated, 0 for no limit")
+	hostLabelFlag := flag.String("host-label", "exporter_host", "label added to every metric with the pod name (POD_NAME), HOSTNAME or hostname to tell replicas apart, empty to disable")
+	aggregateFlag := flag.String("aggregate", "", "comma separated URLs of other rclone-exporter instances whose metrics are fetched and served along with this instance's, labelled with their host:port")
+	aggregateLabelFlag := flag.String("aggregate-label", "exporter_instance", "label identifying the instance of aggregated metrics")
+	aggregateTimeoutFlag := flag.Int("aggregate-timeout", 10, "timeout in seconds for fetching the metrics of each aggregated instance")
+	eventsFlag := flag.Bool("events", false, "serve /events on the metrics listener, streaming an event every time a bucket's metrics are updated as Server-Sent Events")
+	baselineFileFlag := flag.String("baseline-file", "", "file the last good bucket counts are saved to after every cycle and loaded from on startup, exporting how much each bucket changed while the exporter was down")
+	debugEndpointsFlag := flag.Bool("debug-endpoints", false, "serve debugging endpoints such as /debug/config on the metrics listener")
 	logJSONFlag := flag.Bool("log-json", false, "output logs in json")
+	metadataKeyFlag := flag.String("metadata-key", "", "object metadata key to group bucket sizes by (requires reading every object's metadata)")
+	metadataTopNFlag := flag.Int("metadata-top-n", 10, "max number of metadata values exported per bucket, the rest are grouped as \"(other)\"")
+	metadataMaxTrackedFlag := flag.Int("metadata-max-tracked", 10000, "max number of distinct metadata values tracked while walking a bucket, bounding memory at the cost of approximate sizes (0 is unbounded)")
+	ageTiersFlag := flag.String("age-tiers", "", "comma separated ascending age boundaries to count objects between, e.g. 7d,30d,90d (requires walking every object)")
+	hashPresenceFlag := flag.Bool("hash-presence", false, "export the number of objects without a hash per bucket (requires walking every object, and a request per object on some backends)")
+	sizeStddevFlag := flag.Bool("size-stddev", false, "export the standard deviation of object sizes per bucket (requires walking every object)")
+	adaptiveTimeoutMultiplierFlag := flag.Float64("adaptive-timeout-multiplier", 0, "bound each bucket count by this multiple of the bucket's recent mean count duration, unless it has a bucket_timeouts entry, 0 to disable")
+	adaptiveTimeoutMinFlag := flag.Int("adaptive-timeout-min", 60, "floor in seconds of adaptive bucket count timeouts")
+	adaptiveTimeoutMaxFlag := flag.Int("adaptive-timeout-max", 1800, "ceiling in seconds of adaptive bucket count timeouts, also used for a bucket's first count")
+	stallTimeoutFlag := flag.Int("stall-timeout", 0, "let bucket counts still listing objects run past their timeout, aborting them once they list none for this many seconds, 0 to abort them at their timeout")
+	stallTimeoutMaxFlag := flag.Int("stall-timeout-max", 7200, "maximum duration in seconds of a bucket count extended by -stall-timeout")
+	rateLimitCooldownFlag := flag.Int("rate-limit-cooldown", 300, "seconds to suppress scrapes of a remote after it rate limited the exporter, doubling with every consecutive rate limited scrape (0 disables)")
+	minAgeFlag := flag.Int("min-age", 0, "leave objects modified less than this many seconds ago out of bucket and path counts, like rclone's --min-age, so in-flight uploads don't skew them, unless overridden per remote in -config-dir (0 counts every object)")
+	rateLimitCooldownMaxFlag := flag.Int("rate-limit-cooldown-max", 3600, "ceiling in seconds of rate limit cooldowns")
+	storageClassesFlag := flag.String("storage-classes", "", "comma separated storage classes, e.g. STANDARD,GLACIER, to export the size and count of the objects in per bucket on backends reporting them (requires walking every object)")
+	clockSkewFlag := flag.Bool("clock-skew", false, "export the difference between the clock of each remote's HTTP endpoint and the exporter's, read from the Date header of a HEAD request made every scrape")
+	dirCountFlag := flag.Bool("dir-count", false, "export the number of directories per bucket (requires walking every object)")
+	countPseudoDirsFlag := flag.Bool("count-pseudo-dirs", false, "with -dir-count, also count the directories of backends without real directories (e.g. S3 without directory markers, B2), which only exist as prefixes of object names")
+	unknownSizePolicyFlag := flag.String("unknown-size-policy", string(unknownSizeSkip), "how objects with an unknown size are treated when walking a bucket: skip (count them without a size), zero or error")
 	flag.Parse()
 
 	if *logJSONFlag {
 		logrus.SetFormatter(&logrus.JSONFormatter{})
 	}
 
-	if *remotesFlag == "" {
+	if *remotesFlag == "" && *configDirFlag == "" && *mountPathFlag == "" && !*allRemotesFlag && *aggregateFlag == "" {
 		if !*logJSONFlag {
 			flag.Usage()
 		}
-		logrus.Fatal("at least one remote must be configured with -remote")
+		logrus.Fatal("at least one remote must be configured with -remote, -mount-path, -config-dir or -all-remotes, or instances to aggregate with -aggregate")
 	}
 
+	// Install config file (required by rclone)
+	configfile.Install()
+	countTokenRefreshes()
+
 	// Split the comma separated remotes into a slice
-	remotes := []string{}
-	for _, remote := range strings.Split(*remotesFlag, ",") {
-		remotes = append(remotes, strings.TrimSpace(remote))
+	flagRemotes := []remoteConfig{}
+	if *remotesFlag != "" {
+		for _, remote := range strings.Split(*remotesFlag, ",") {
+			flagRemotes = append(flagRemotes, remoteConfig{Name: strings.TrimSpace(remote), Source: "flag"})
+		}
+	}
+	// Mounted remotes are read through the local backend, so the exporter needs no credentials of
+	// its own. The trailing slash lets bucket names be appended to the path
+	if *mountPathFlag != "" {
+		for _, mountPath := range strings.Split(*mountPathFlag, ",") {
+			mountPath = strings.TrimSuffix(filepath.Clean(strings.TrimSpace(mountPath)), "/") + "/"
+			flagRemotes = append(flagRemotes, remoteConfig{Name: mountPath, Source: "flag"})
+		}
+	}
+	if *allRemotesFlag {
+		var pattern *regexp.Regexp
+		if *allRemotesRegexFlag != "" {
+			var err error
+			if pattern, err = regexp.Compile(*allRemotesRegexFlag); err != nil {
+				logrus.WithError(err).Fatal("invalid -all-remotes-regex")
+			}
+		}
+		discovered := discoverRemotes(pattern)
+		logrus.WithField("remotes", len(discovered)).Info("discovered remotes in the rclone config")
+		flagRemotes = append(flagRemotes, discovered...)
+	}
+	dirRemotes := []remoteConfig{}
+	var fingerprint [sha256.Size]byte
+	if *configDirFlag != "" {
+		var err error
+		dirRemotes, fingerprint, err = loadConfigDir(*configDirFlag)
+		if err != nil {
+			logrus.WithField("dir", *configDirFlag).WithError(err).Fatal("failed loading config directory")
+		}
+	}
+	merged, err := mergeRemotes(flagRemotes, dirRemotes)
+	if err != nil {
+		logrus.WithError(err).Fatal("invalid remote configuration")
+	}
+	// The monitored remotes are swapped atomically when the config directory is reloaded
+	var remotes atomic.Pointer[[]remoteConfig]
+	remotes.Store(&merged)
+	sizePolicy, err := parseUnknownSizePolicy(*unknownSizePolicyFlag)
+	if err != nil {
+		logrus.WithError(err).Fatal("invalid -unknown-size-policy")
+	}
+	ageTiers, err := parseAgeTiers(*ageTiersFlag)
+	if err != nil {
+		logrus.WithError(err).Fatal("invalid -age-tiers")
+	}
+	storageClasses, err := parseStorageClasses(*storageClassesFlag)
+	if err != nil {
+		logrus.WithError(err).Fatal("invalid -storage-classes")
+	}
+	if *successWindowFlag < 1 {
+		logrus.Fatal("-success-window must be at least 1")
+	}
+	if *regressionThresholdFlag < 0 {
+		logrus.Fatal("-regression-threshold must be positive")
+	}
+	if *samplePrefixesFlag < 2 {
+		logrus.Fatal("-sample-prefixes must be at least 2 to estimate the sampling error")
+	}
+	backendLimiters, err := parseBackendConcurrency(*backendConcurrencyFlag)
+	if err != nil {
+		logrus.WithError(err).Fatal("invalid -backend-concurrency")
+	}
+	missingPolicy, err := parseMissingRemotePolicy(*onMissingRemoteFlag)
+	if err != nil {
+		logrus.WithError(err).Fatal("invalid -on-missing-remote")
+	}
+	if *rateLimitCooldownFlag > *rateLimitCooldownMaxFlag {
+		logrus.Fatal("-rate-limit-cooldown must not be greater than -rate-limit-cooldown-max")
+	}
+	if *adaptiveTimeoutMultiplierFlag > 0 && *adaptiveTimeoutMinFlag > *adaptiveTimeoutMaxFlag {
+		logrus.Fatal("-adaptive-timeout-min must not be greater than -adaptive-timeout-max")
+	}
+	if *stallTimeoutFlag > 0 && *stallTimeoutMaxFlag < 1 {
+		logrus.Fatal("-stall-timeout-max must be positive")
+	}
+	if *maxBucketsFlag > 0 && *sampleAboveBucketsFlag >= *maxBucketsFlag {
+		logrus.Fatal("-sample-above-buckets must be lower than -max-buckets for sampling to ever apply")
+	}
+	opts := &options{
+		clock:                     realClock{},
+		updatePeriod:              time.Duration(*updatePeriodFlag) * time.Minute,
+		remoteTimeout:             time.Duration(*remoteTimeoutFlag) * time.Second,
+		alignSchedule:             *alignScheduleFlag,
+		sequential:                *sequentialFlag,
+		bucketConcurrency:         *bucketConcurrencyFlag,
+		readOnly:                  *readOnlyFlag,
+		stalestFirst:              *stalestFirstFlag,
+		progressInterval:          time.Duration(*progressIntervalFlag) * time.Second,
+		staleSeriesGraceCycles:    *staleSeriesGraceCyclesFlag,
+		limiter:                   newLimiter(*concurrencyFlag),
+		backendLimiters:           backendLimiters,
+		about:                     *aboutFlag,
+		anomalyWindow:             *anomalyWindowFlag,
+		anomalyThreshold:          *anomalyThresholdFlag,
+		multipartUploads:          *multipartUploadsFlag,
+		samplePrefixes:            *samplePrefixesFlag,
+		proceedOnPartialListing:   *proceedOnPartialListingFlag,
+		sampleAboveBuckets:        *sampleAboveBucketsFlag,
+		maxBuckets:                *maxBucketsFlag,
+		onMissingRemote:           missingPolicy,
+		countRoot:                 *countRootFlag,
+		rootBucketName:            *rootBucketNameFlag,
+		listingConcurrency:        *listingConcurrencyFlag,
+		regressionThreshold:       *regressionThresholdFlag,
+		failOnRegression:          *failOnRegressionFlag,
+		fastList:                  *fastListFlag,
+		successWindow:             *successWindowFlag,
+		retries:                   *retriesFlag,
+		metadataKey:               *metadataKeyFlag,
+		metadataTopN:              *metadataTopNFlag,
+		metadataMaxTracked:        *metadataMaxTrackedFlag,
+		ageTiers:                  ageTiers,
+		hashPresence:              *hashPresenceFlag,
+		sizeStddev:                *sizeStddevFlag,
+		dirCount:                  *dirCountFlag,
+		storageClasses:            storageClasses,
+		countPseudoDirs:           *countPseudoDirsFlag,
+		unknownSizePolicy:         sizePolicy,
+		adaptiveTimeoutMultiplier: *adaptiveTimeoutMultiplierFlag,
+		adaptiveTimeoutMin:        time.Duration(*adaptiveTimeoutMinFlag) * time.Second,
+		adaptiveTimeoutMax:        time.Duration(*adaptiveTimeoutMaxFlag) * time.Second,
+		stallTimeout:              time.Duration(*stallTimeoutFlag) * time.Second,
+		stallTimeoutMax:           time.Duration(*stallTimeoutMaxFlag) * time.Second,
+		rateLimitCooldown:         time.Duration(*rateLimitCooldownFlag) * time.Second,
+		rateLimitCooldownMax:      time.Duration(*rateLimitCooldownMaxFlag) * time.Second,
+		minAge:                    time.Duration(*minAgeFlag) * time.Second,
+		clockSkew:                 *clockSkewFlag,
 	}
-	timeout := time.Duration(*remoteTimeoutFlag) * time.Second
 
-	ctx := context.Background()
-	// Install config file (required by rclone)
-	configfile.Install()
+	if *eventsFlag {
+		opts.events = newEventBroadcaster()
+	}
+	if *baselineFileFlag != "" {
+		if opts.baseline, err = loadBaseline(*baselineFileFlag); err != nil {
+			logrus.WithField("path", *baselineFileFlag).WithError(err).Fatal("failed loading baseline snapshot")
+		}
+	}
+
+	if *socksProxyFlag != "" {
+		if err := useSOCKSProxy(*socksProxyFlag); err != nil {
+			logrus.WithError(err).Fatal("invalid -socks-proxy")
+		}
+	}
+
+	// Bound connection setup separately from the scrape timeout so a single hung connection
+	// doesn't consume the whole scrape budget. rclone applies ConnectTimeout to both the dial
+	// and the TLS handshake of every backend HTTP client built from this context
+	ctx, ci := fs.AddConfig(context.Background())
+	ci.ConnectTimeout = time.Duration(*connectTimeoutFlag) * time.Second
+	if *caCertFlag != "" {
+		if err := checkCABundle(*caCertFlag); err != nil {
+			logrus.WithError(err).Fatal("invalid -ca-cert")
+		}
+		ci.CaCert = []string{*caCertFlag}
+	}
+	if opts.readOnly {
+		ctx = withReadOnly(ctx)
+	}
+	updateBackendInfo()
+	check := func(remotes []remoteConfig) error {
+		return checkRemotes(remotes, opts)
+	}
+	if err := check(merged); err != nil {
+		logrus.WithError(err).Fatal("invalid remote configuration")
+	}
+	updateEffectivePeriods(merged, opts)
+	updateSummaryOnlyRemotes(merged)
+	// An invalid config at startup is fatal, so the running one is valid until a reload fails
+	configValid.Set(1)
+
+	if *configDirFlag != "" && *reloadOnChangeFlag {
+		go watchConfigDir(ctx, opts.clock, *configDirFlag, fingerprint, flagRemotes, check, func(reloaded []remoteConfig) {
+			remotes.Store(&reloaded)
+			updateEffectivePeriods(reloaded, opts)
+			updateSummaryOnlyRemotes(reloaded)
+		})
+	}
+
+	var labels localLabels
+	if *bucketLabelRegexFlag != "" {
+		// Labels added to every metric, and to those of aggregated instances, can't be derived
+		reserved := []string{*hostLabelFlag}
+		if *aggregateFlag != "" {
+			reserved = append(reserved, *aggregateLabelFlag)
+		}
+		labeler, err := newBucketLabeler(*bucketLabelRegexFlag, reserved...)
+		if err != nil {
+			logrus.WithError(err).Fatal("invalid -bucket-label-regex")
+		}
+		labels.labeler = labeler
+	}
+	if *hostLabelFlag != "" {
+		if !model.LabelName(*hostLabelFlag).IsValid() {
+			logrus.WithField("label", *hostLabelFlag).Fatal("invalid -host-label")
+		}
+		host, err := hostLabelValue()
+		if err != nil {
+			logrus.WithError(err).Fatal("failed getting hostname for -host-label")
+		}
+		labels.hostLabel, labels.host = *hostLabelFlag, host
+	}
+	// Bucket series of summary_only remotes are left out before anything else sees them
+	gatherer := labels.wrap(summaryOnlyGatherer{Gatherer: prometheus.DefaultGatherer})
+	if *aggregateFlag != "" {
+		if !model.LabelName(*aggregateLabelFlag).IsValid() {
+			logrus.WithField("label", *aggregateLabelFlag).Fatal("invalid -aggregate-label")
+		}
+		aggregator, err := newAggregatingGatherer(*aggregateFlag, *aggregateLabelFlag, time.Duration(*aggregateTimeoutFlag)*time.Second)
+		if err != nil {
+			logrus.WithError(err).Fatal("invalid -aggregate")
+		}
+		// The aggregated metrics are gathered first so rclone_aggregate_downstream_up is current
+		gatherer = prometheus.Gatherers{aggregator, gatherer}
+	}
+
+	// Optionally push the size and count gauges to StatsD after every cycle, alongside /metrics
+	var statsd *statsdClient
+	if *statsdAddrFlag != "" {
+		format, err := parseStatsdTagFormat(*statsdTagFormatFlag)
+		if err != nil {
+			logrus.WithError(err).Fatal("invalid -statsd-tag-format")
+		}
+		statsd, err = newStatsdClient(*statsdAddrFlag, format)
+		if err != nil {
+			logrus.WithError(err).Fatal("failed creating StatsD client")
+		}
+	}
+	update := func() {
+		updateRemotes(ctx, *remotes.Load(), opts)
+		if err := opts.baseline.save(); err != nil {
+			logrus.WithField("path", *baselineFileFlag).WithError(err).Error("failed saving baseline snapshot")
+		}
+		if statsd != nil {
+			if err := statsd.send(gatherer); err != nil {
+				logrus.WithField("address", *statsdAddrFlag).WithError(err).Error("failed sending metrics to StatsD")
+			}
+		}
+	}
 
 	// Start a goroutine to periodically update bucket metrics
 	go func() {
-		ticker := time.NewTicker(time.Duration(*updatePeriodFlag) * time.Minute)
-		defer ticker.Stop()
 		// Run an update immediately
-		ctxTimeout, cancel := context.WithTimeout(ctx, timeout)
-		updateRemotes(ctxTimeout, remotes)
-		cancel()
+		update()
+		if opts.alignSchedule {
+			// Wait for the next boundary of the period, e.g. the top of the hour, so the periodic
+			// scrapes of every instance happen at the same predictable times
+			select {
+			case <-opts.clock.After(untilNextBoundary(opts.clock.Now(), opts.updatePeriod)):
+			case <-ctx.Done():
+				return
+			}
+		}
+		ticker := opts.clock.NewTicker(opts.updatePeriod)
+		defer ticker.Stop()
+		if opts.alignSchedule {
+			update()
+		}
 		// Update periodically
 		for {
 			select {
-			case <-ticker.C:
-				ctxTimeout, cancel := context.WithTimeout(ctx, timeout)
-				updateRemotes(ctxTimeout, remotes)
-				cancel()
+			case <-ticker.C():
+				update()
 			case <-ctx.Done():
 				return
 			}
@@ -164,9 +1479,38 @@ func main() {
 	}()
 
 	// Expose Prometheus metrics via HTTP
-	http.Handle("/metrics", promhttp.Handler())
+	exporterStartTime.Set(float64(opts.clock.Now().Unix()))
+	mux := http.NewServeMux()
+	metricsHandler := promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})
+	if *metricsMaxBytesFlag > 0 {
+		// The truncation metric is served apart from the others, but labelled like them
+		metricsHandler = cappedMetricsHandler(gatherer, labels.wrap(truncationRegistry), *metricsMaxBytesFlag)
+	}
+	handleInstrumented(mux, "/metrics", "/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, metricsHandler))
+	if opts.events != nil {
+		handleInstrumented(mux, "/events", "/events", eventsHandler(opts.events))
+	}
+	if *debugEndpointsFlag {
+		handleInstrumented(mux, "/debug/config", "/debug/config", debugConfigHandler(&remotes))
+		handleInstrumented(mux, "/debug/rclone-stats", "/debug/rclone-stats", rcloneStatsHandler(opts.clock))
+	}
+	// Count requests to any other path together so unexpected traffic is visible
+	handleInstrumented(mux, "/", "other", http.NotFoundHandler())
+	listener, err := listen(*listenAddrFlag)
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to listen")
+	}
+	server := &http.Server{Handler: mux}
+	// Closing the server closes the listener, which removes a Unix socket
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-signals
+		logrus.WithField("signal", sig).Info("shutting down")
+		server.Close()
+	}()
 	logrus.WithField("address", *listenAddrFlag+"/metrics").Info("serving Prometheus metrics")
-	if err := http.ListenAndServe(*listenAddrFlag, nil); err != nil {
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		logrus.WithError(err).Fatal("failed to start HTTP server")
 	}
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rclone/rclone/fs"
+)
+
+var (
+	bucketRecentObjectsExcluded = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_bucket_recent_objects_excluded",
+			Help: "Number of objects left out of a bucket's last count for being modified more recently than the minimum age",
+		},
+		[]string{"remote", "bucket"},
+	)
+	pathRecentObjectsExcluded = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_path_recent_objects_excluded",
+			Help: "Number of objects left out of a configured path's last count for being modified more recently than the minimum age",
+		},
+		[]string{"remote", "name"},
+	)
+)
+
+func init() {
+	mustRegisterRemoteVec(bucketRecentObjectsExcluded, "remote", "bucket")
+	mustRegisterRemoteVec(pathRecentObjectsExcluded, "remote", "name")
+}
+
+// minAgeCutoff leaves out objects modified less than opts.minAge ago, as rclone's --min-age does.
+// The cutoff is fixed when it's created, so one is created for every count and walk
+type minAgeCutoff struct {
+	cutoff time.Time
+}
+
+// newMinAgeCutoff returns the cutoff for opts.minAge by opts.clock, nil when it's disabled
+func newMinAgeCutoff(opts *options) *minAgeCutoff {
+	if opts.minAge <= 0 {
+		return nil
+	}
+	return &minAgeCutoff{cutoff: opts.clock.Now().Add(-opts.minAge)}
+}
+
+// recent reports whether o was modified after the cutoff. A nil cutoff leaves out nothing
+func (c *minAgeCutoff) recent(ctx context.Context, o fs.Object) bool {
+	return c != nil && o.ModTime(ctx).After(c.cutoff)
+}
+
+// withServerModTime returns a context using the upload time returned by the listing rather than
+// the modification time rclone stores in metadata, which some backends can only read with a
+// request per object
+func withServerModTime(ctx context.Context) context.Context {
+	ctx, ci := fs.AddConfig(ctx)
+	ci.UseServerModTime = true
+	return ctx
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+func TestMinAgeWalkAgreesWithCount(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().Truncate(time.Second)
+	for name, modTime := range map[string]time.Time{
+		"old":             now.Add(-2 * time.Hour),
+		"sub/old":         now.Add(-3 * time.Hour),
+		"uploading":       now.Add(-time.Minute),
+		"sub/uploading":   now,
+		"sub/at-boundary": now.Add(-time.Hour),
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &options{
+		clock:             newFakeClock(now),
+		minAge:            time.Hour,
+		unknownSizePolicy: unknownSizeSkip,
+		sizeStddev:        true,
+	}
+	files, size, excluded, err := countObjects(ctx, f, opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files != 3 || excluded != 2 {
+		t.Errorf("counted %d objects and excluded %d, want 3 and 2", files, excluded)
+	}
+	result, err := walkBucket(ctx, f, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.objects != files || result.size != size {
+		t.Errorf("walk found %d objects of %d bytes, count found %d of %d", result.objects, result.size, files, size)
+	}
+}
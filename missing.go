@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rclone/rclone/fs"
+	"github.com/sirupsen/logrus"
+)
+
+var remoteMissingConfig = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "rclone_remote_missing_config",
+		Help: "Whether a remote's section is missing from the rclone config, labelled with the -on-missing-remote policy applied",
+	},
+	[]string{"remote", "policy"},
+)
+
+func init() {
+	mustRegisterRemoteVec(remoteMissingConfig, "remote", "policy")
+}
+
+// missingRemotePolicy controls what happens to remotes whose section isn't in the rclone config
+type missingRemotePolicy string
+
+const (
+	// missingRemoteFail refuses to start when a remote's section is missing. Sections removed
+	// after startup are retried
+	missingRemoteFail missingRemotePolicy = "fail"
+	// missingRemoteSkip doesn't scrape the remote and removes its series until the section exists
+	missingRemoteSkip missingRemotePolicy = "skip"
+	// missingRemoteRetry scrapes the remote as usual, failing each scrape until the section exists
+	missingRemoteRetry missingRemotePolicy = "retry"
+)
+
+// parseMissingRemotePolicy validates s as a missingRemotePolicy
+func parseMissingRemotePolicy(s string) (missingRemotePolicy, error) {
+	switch policy := missingRemotePolicy(s); policy {
+	case missingRemoteFail, missingRemoteSkip, missingRemoteRetry:
+		return policy, nil
+	}
+	return "", fmt.Errorf("missing remote policy %q must be one of %q, %q or %q", s, missingRemoteFail, missingRemoteSkip, missingRemoteRetry)
+}
+
+// isMissingRemote reports whether the remote refers to a section that isn't in the rclone config,
+// as opposed to failing for any other reason. It doesn't make any requests to the remote
+func isMissingRemote(remote string) bool {
+	_, _, _, _, err := fs.ParseRemote(remote)
+	return errors.Is(err, fs.ErrorNotFoundInConfigFile)
+}
+
+// checkMissingRemotes returns an error naming the first remote whose section is missing
+func checkMissingRemotes(remotes []remoteConfig) error {
+	for _, remote := range remotes {
+		if isMissingRemote(remote.Name) {
+			return fmt.Errorf("remote %q isn't defined in the rclone config", remote.Name)
+		}
+	}
+	return nil
+}
+
+// skipMissingRemotes records which remotes are missing from the rclone config and returns the
+// remotes to scrape, leaving out the missing ones when the policy is to skip them
+func skipMissingRemotes(remotes []remoteConfig, opts *options) []remoteConfig {
+	scraped := []remoteConfig{}
+	for _, remote := range remotes {
+		missing := isMissingRemote(remote.Name)
+		if missing && opts.onMissingRemote == missingRemoteSkip {
+			logrus.WithField("remote", remote.Name).Warn("remote isn't defined in the rclone config, skipping")
+			deleteRemoteSeries(remote.Name)
+		} else {
+			scraped = append(scraped, remote)
+		}
+		value := 0.0
+		if missing {
+			value = 1
+		}
+		remoteMissingConfig.WithLabelValues(remote.Name, string(opts.onMissingRemote)).Set(value)
+	}
+	return scraped
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rclone/rclone/fs"
+	"github.com/sirupsen/logrus"
+)
+
+var bucketIncompleteMultipart = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "rclone_bucket_incomplete_multipart_count",
+		Help: "Number of incomplete multipart uploads in a bucket, on backends that can list them. Their size isn't reported by rclone, so it isn't exported",
+	},
+	[]string{"remote", "bucket"},
+)
+
+func init() {
+	mustRegisterRemoteVec(bucketIncompleteMultipart, "remote", "bucket")
+}
+
+// errMultipartUnsupported is returned by countMultipartUploads for backends that can't list their
+// incomplete multipart uploads
+var errMultipartUnsupported = errors.New("backend can't list incomplete multipart uploads")
+
+// countMultipartUploads counts the bucket's incomplete multipart uploads using the backend's
+// list-multipart-uploads command, which only the S3 backend implements. The command doesn't return
+// the size of the uploaded parts, so they can't be summed
+func countMultipartUploads(ctx context.Context, bucketFs fs.Fs) (int, error) {
+	command := bucketFs.Features().Command
+	if command == nil {
+		return 0, errMultipartUnsupported
+	}
+	out, err := command(ctx, "list-multipart-uploads", nil, nil)
+	if errors.Is(err, fs.ErrorCommandNotFound) {
+		return 0, errMultipartUnsupported
+	}
+	if err != nil {
+		return 0, err
+	}
+	uploads, ok := out.(map[string][]types.MultipartUpload)
+	if !ok {
+		return 0, fmt.Errorf("unexpected list-multipart-uploads result %T", out)
+	}
+	count := 0
+	for _, bucketUploads := range uploads {
+		count += len(bucketUploads)
+	}
+	return count, nil
+}
+
+// updateMultipartMetrics counts the bucket's incomplete multipart uploads. Backends that can't
+// report them are skipped without exporting the metric
+func updateMultipartMetrics(ctx context.Context, remote, bucketName string, bucketFs fs.Fs, contextLogger *logrus.Entry) {
+	count, err := countMultipartUploads(ctx, bucketFs)
+	if errors.Is(err, errMultipartUnsupported) {
+		contextLogger.Debug("backend can't list incomplete multipart uploads, skipping")
+		return
+	}
+	if err != nil {
+		contextLogger.WithError(err).Error("failed listing incomplete multipart uploads")
+		recordRemoteError(remote, "multipart", err)
+		return
+	}
+	bucketIncompleteMultipart.WithLabelValues(remote, bucketName).Set(float64(count))
+}
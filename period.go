@@ -0,0 +1,56 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var remoteEffectivePeriod = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "rclone_remote_effective_period_seconds",
+		Help: "Time in seconds between scrapes of a remote, as currently configured",
+	},
+	[]string{"remote"},
+)
+
+func init() {
+	mustRegisterRemoteVec(remoteEffectivePeriod, "remote")
+}
+
+// updatePeriod returns the time between the remote's scrapes. Remotes are only scraped on the
+// ticks of -update-period, so a remote's period is rounded up to a whole number of them
+func (r *remoteConfig) updatePeriod(opts *options) time.Duration {
+	if r.UpdatePeriod <= opts.updatePeriod {
+		return opts.updatePeriod
+	}
+	ticks := (r.UpdatePeriod + opts.updatePeriod - 1) / opts.updatePeriod
+	return ticks * opts.updatePeriod
+}
+
+// updateEffectivePeriods exports the period each remote is scraped with, called whenever the
+// remotes are (re)configured
+func updateEffectivePeriods(remotes []remoteConfig, opts *options) {
+	for _, remote := range remotes {
+		remoteEffectivePeriod.WithLabelValues(remote.Name).Set(remote.updatePeriod(opts).Seconds())
+	}
+}
+
+// dueRemotes returns the remotes whose period has elapsed since their last scrape started and that
+// aren't cooling down after being rate limited. Ticks and scrape starts don't line up exactly, so
+// half a tick of slack keeps a remote from slipping to the next tick
+func dueRemotes(remotes []remoteConfig, opts *options) []remoteConfig {
+	now := opts.clock.Now()
+	due := []remoteConfig{}
+	for _, remote := range remotes {
+		isDue := true
+		state.remote(remote.Name, func(r *remoteState) {
+			isDue = r.lastStart.IsZero() || now.Sub(r.lastStart) >= remote.updatePeriod(opts)-opts.updatePeriod/2
+			isDue = isDue && !now.Before(r.cooldownUntil)
+		})
+		if isDue {
+			due = append(due, remote)
+		}
+	}
+	return due
+}
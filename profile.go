@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+)
+
+// profileConfig is a named bundle of opt-in collectors that remotes can share. A remote assigned a
+// profile runs exactly the profile's collectors instead of those enabled by flags. Settings that
+// don't add requests or walks, such as -min-age and the file count anomaly detection, aren't
+// collectors and keep applying to remotes with a profile
+type profileConfig struct {
+	// About enables fetching quota information with About
+	About bool `yaml:"about" json:"about,omitempty"`
+	// MultipartUploads enables counting incomplete multipart uploads
+	MultipartUploads bool `yaml:"multipart_uploads" json:"multipart_uploads,omitempty"`
+	// Sample estimates bucket sizes from samples instead of counting them
+	Sample bool `yaml:"sample" json:"sample,omitempty"`
+	// MetadataKey groups bucket sizes by the value of this object metadata key
+	MetadataKey string `yaml:"metadata_key" json:"metadata_key,omitempty"`
+	// MetadataTopN caps the number of metadata values exported per bucket, 0 keeps -metadata-top-n
+	MetadataTopN int `yaml:"metadata_top_n" json:"metadata_top_n,omitempty"`
+	// AgeTiers are the age boundaries objects are counted between, e.g. "7d,30d,90d"
+	AgeTiers string `yaml:"age_tiers" json:"age_tiers,omitempty"`
+	// HashPresence enables counting objects without a hash
+	HashPresence bool `yaml:"hash_presence" json:"hash_presence,omitempty"`
+	// SizeStddev enables exporting the standard deviation of object sizes
+	SizeStddev bool `yaml:"size_stddev" json:"size_stddev,omitempty"`
+	// DirCount enables exporting the number of directories per bucket
+	DirCount bool `yaml:"dir_count" json:"dir_count,omitempty"`
+	// CountPseudoDirs also counts the directories of backends without real directories, with
+	// DirCount
+	CountPseudoDirs bool `yaml:"count_pseudo_dirs" json:"count_pseudo_dirs,omitempty"`
+	// StorageClasses are the comma separated storage classes objects are counted in, e.g.
+	// "STANDARD,GLACIER"
+	StorageClasses string `yaml:"storage_classes" json:"storage_classes,omitempty"`
+	// ClockSkew enables comparing the remote endpoint's clock with the exporter's
+	ClockSkew bool `yaml:"clock_skew" json:"clock_skew,omitempty"`
+
+	// ageTiers holds AgeTiers once parsed
+	ageTiers []ageTier
+	// storageClasses holds StorageClasses once parsed
+	storageClasses []string
+}
+
+// validate checks the profile's settings and parses its age tiers
+func (p *profileConfig) validate() error {
+	if p.MetadataTopN < 0 {
+		return fmt.Errorf("metadata_top_n must be positive")
+	}
+	ageTiers, err := parseAgeTiers(p.AgeTiers)
+	if err != nil {
+		return fmt.Errorf("invalid age_tiers: %w", err)
+	}
+	p.ageTiers = ageTiers
+	storageClasses, err := parseStorageClasses(p.StorageClasses)
+	if err != nil {
+		return fmt.Errorf("invalid storage_classes: %w", err)
+	}
+	p.storageClasses = storageClasses
+	return nil
+}
+
+// apply returns a copy of opts with the collectors replaced by the profile's. Object filters are
+// reset too, as they're only ever set by the remote itself
+func (p *profileConfig) apply(opts *options) *options {
+	applied := *opts
+	applied.about = p.About
+	applied.multipartUploads = p.MultipartUploads
+	applied.metadataKey = p.MetadataKey
+	if p.MetadataTopN > 0 {
+		applied.metadataTopN = p.MetadataTopN
+	}
+	applied.ageTiers = p.ageTiers
+	applied.hashPresence = p.HashPresence
+	applied.sizeStddev = p.SizeStddev
+	applied.dirCount = p.DirCount
+	applied.countPseudoDirs = p.CountPseudoDirs
+	applied.storageClasses = p.storageClasses
+	applied.clockSkew = p.ClockSkew
+	applied.objectFilters = nil
+	return &applied
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+)
+
+// countObjects counts the objects in f like operations.Count. If progress is given, it's called
+// with the running totals every opts.progressInterval until the count finishes. Objects modified
+// less than opts.minAge ago are left out and counted in excluded. Every object listed is reported
+// to the count's stallWatch, if it has one
+func countObjects(ctx context.Context, f fs.Fs, opts *options, progress func(files, size int64)) (files, size, excluded int64, err error) {
+	minAge := newMinAgeCutoff(opts)
+	if minAge != nil {
+		ctx = withServerModTime(ctx)
+	}
+	watch := stallWatchFrom(ctx)
+	var runningFiles, runningSize, runningExcluded atomic.Int64
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	if progress == nil || opts.progressInterval <= 0 {
+		close(stopped)
+	} else {
+		go publishProgress(opts, stop, stopped, func() {
+			progress(runningFiles.Load(), runningSize.Load())
+		})
+	}
+	err = operations.ListFn(ctx, f, func(o fs.Object) {
+		watch.progress()
+		if minAge.recent(ctx, o) {
+			runningExcluded.Add(1)
+			return
+		}
+		runningFiles.Add(1)
+		if objectSize := o.Size(); objectSize > 0 {
+			runningSize.Add(objectSize)
+		}
+	})
+	close(stop)
+	// Wait so no progress is published after the caller sets the final counts
+	<-stopped
+	return runningFiles.Load(), runningSize.Load(), runningExcluded.Load(), err
+}
+
+// publishProgress calls publish every opts.progressInterval until stop is closed, then closes
+// stopped
+func publishProgress(opts *options, stop <-chan struct{}, stopped chan<- struct{}, publish func()) {
+	defer close(stopped)
+	ticker := opts.clock.NewTicker(opts.progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			publish()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// publishBucketProgress sets the bucket's size and count gauges to the running totals of a count
+// in progress
+func publishBucketProgress(remote, bucketName string) func(files, size int64) {
+	return func(files, size int64) {
+		bucketSize.WithLabelValues(remote, bucketName).Set(float64(size))
+		bucketFileCount.WithLabelValues(remote, bucketName).Set(float64(files))
+	}
+}
+
+// restoreBucketCounts sets the bucket's size and count gauges back to its last good count, undoing
+// the progress published by a count that didn't complete
+func restoreBucketCounts(remote, bucketName string) {
+	var b bucketState
+	state.bucket(remote, bucketName, func(s *bucketState) {
+		b = *s
+	})
+	if !b.sized {
+		bucketSize.DeleteLabelValues(remote, bucketName)
+		bucketFileCount.DeleteLabelValues(remote, bucketName)
+		return
+	}
+	bucketSize.WithLabelValues(remote, bucketName).Set(float64(b.lastSize))
+	bucketFileCount.WithLabelValues(remote, bucketName).Set(float64(b.lastFiles))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// exporterProxy picks the proxy of the exporter's own HTTP clients, such as -aggregate's. It stays
+// the environment's proxy from before -socks-proxy changed it, so only rclone uses the SOCKS proxy
+var exporterProxy = http.ProxyFromEnvironment
+
+// useSOCKSProxy routes rclone's HTTP requests to the remotes through a SOCKS5 proxy. rclone builds
+// every backend's transport with http.ProxyFromEnvironment and has no hook for a custom dialer, so
+// the proxy has to be set in the environment before any backend is created. Go's transport then
+// dials socks5 proxy URLs itself. The exporter's own clients keep the proxy settings the
+// environment had before
+func useSOCKSProxy(proxy string) error {
+	if !strings.Contains(proxy, "://") {
+		proxy = "socks5://" + proxy
+	}
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return err
+	}
+	if proxyURL.Scheme != "socks5" && proxyURL.Scheme != "socks5h" {
+		return fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return fmt.Errorf("proxy %q has no host", proxyURL.Redacted())
+	}
+	previous := httpproxy.FromEnvironment().ProxyFunc()
+	exporterProxy = func(req *http.Request) (*url.URL, error) {
+		return previous(req.URL)
+	}
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY"} {
+		if err := os.Setenv(name, proxyURL.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newExporterTransport returns a transport for the exporter's own HTTP clients, which don't go
+// through -socks-proxy
+func newExporterTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		return exporterProxy(req)
+	}
+	return transport
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/sirupsen/logrus"
+)
+
+// statsGroupPrefix prefixes the name of the rclone stats group each remote's scrape is accounted in
+const statsGroupPrefix = "rclone-exporter/"
+
+// scrapeStats is rclone's accounting of a remote's most recent scrape
+type scrapeStats struct {
+	stats *accounting.StatsInfo
+	start time.Time
+	// end is when the scrape finished, zero while it's in progress
+	end time.Time
+}
+
+// rcloneStatsResponse is the JSON served by /debug/rclone-stats
+type rcloneStatsResponse struct {
+	Remote          string    `json:"remote"`
+	Start           time.Time `json:"start"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	InProgress      bool      `json:"in_progress"`
+	Stats           rc.Params `json:"stats"`
+}
+
+// withScrapeStats returns ctx accounting everything rclone does with it in a fresh stats group for
+// the remote, replacing the group of its previous scrape, and a function marking the scrape done.
+// Groups are deleted through the rc call since rclone doesn't export a function for it
+func withScrapeStats(ctx context.Context, remote string, clk clock) (context.Context, func()) {
+	group := statsGroupPrefix + remote
+	if call := rc.Calls.Get("core/stats-delete"); call != nil {
+		if _, err := call.Fn(ctx, rc.Params{"group": group}); err != nil {
+			logrus.WithField("remote", remote).WithError(err).Debug("failed deleting previous stats group")
+		}
+	}
+	stats := &scrapeStats{stats: accounting.NewStatsGroup(ctx, group), start: clk.Now()}
+	state.remote(remote, func(r *remoteState) {
+		r.scrapeStats = stats
+	})
+	return accounting.WithStatsGroup(ctx, group), func() {
+		state.remote(remote, func(*remoteState) {
+			stats.end = clk.Now()
+		})
+	}
+}
+
+// rcloneStatsHandler serves rclone's accounting of the most recent scrape of the remote named by
+// the remote query parameter as JSON
+func rcloneStatsHandler(clk clock) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote := r.URL.Query().Get("remote")
+		if remote == "" {
+			http.Error(w, "missing remote parameter", http.StatusBadRequest)
+			return
+		}
+		// Look the remote up without creating state for arbitrary names
+		var stats scrapeStats
+		state.mu.Lock()
+		rs := state.remotes[remote]
+		found := rs != nil && rs.scrapeStats != nil
+		if found {
+			stats = *rs.scrapeStats
+		}
+		state.mu.Unlock()
+		if !found {
+			http.Error(w, "no scrape of remote "+remote, http.StatusNotFound)
+			return
+		}
+		response := rcloneStatsResponse{
+			Remote:     remote,
+			Start:      stats.start,
+			InProgress: stats.end.IsZero(),
+		}
+		end := stats.end
+		if response.InProgress {
+			end = clk.Now()
+		}
+		response.DurationSeconds = end.Sub(stats.start).Seconds()
+		var err error
+		if response.Stats, err = stats.stats.RemoteStats(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// rclone's elapsed time keeps running after the scrape finished
+		response.Stats["elapsedTime"] = response.DurationSeconds
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(response); err != nil {
+			logrus.WithError(err).Error("failed writing rclone stats")
+		}
+	})
+}
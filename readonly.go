@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// errReadOnly is returned by collectors that need to write while -read-only is set
+var errReadOnly = errors.New("writes are disabled by -read-only")
+
+// readOnlyDisabledFeatures are the optional backend features that modify the remote
+var readOnlyDisabledFeatures = []string{
+	"Purge", "Copy", "Move", "DirMove", "MkdirMetadata", "PublicLink", "PutUnchecked", "PutStream",
+	"MergeDirs", "DirSetModTime", "CleanUp", "OpenWriterAt", "OpenChunkWriter", "Disconnect",
+}
+
+// withReadOnly returns ctx configured so rclone refuses to modify the remotes: its operations skip
+// anything destructive and the backends' optional modifying features are disabled. The core write
+// methods every backend implements can't be disabled, so collectors calling them directly must
+// check -read-only themselves
+func withReadOnly(ctx context.Context) context.Context {
+	ctx, ci := fs.AddConfig(ctx)
+	ci.DryRun = true
+	ci.DisableFeatures = append(slices.Clone(ci.DisableFeatures), readOnlyDisabledFeatures...)
+	return ctx
+}
+
+// checkReadOnly returns an error if a remote enables a collector that writes to it
+func checkReadOnly(remotes []remoteConfig) error {
+	for _, remote := range remotes {
+		if remote.Canary != nil {
+			return fmt.Errorf("remote %q has a canary, which writes to its buckets: %w", remote.Name, errReadOnly)
+		}
+	}
+	return nil
+}
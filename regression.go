@@ -0,0 +1,40 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var bucketRegressionDetected = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "rclone_bucket_regression_detected",
+		Help: "Whether a bucket's file count or size dropped by more than the regression threshold since the previous scrape",
+	},
+	[]string{"remote", "bucket"},
+)
+
+func init() {
+	mustRegisterRemoteVec(bucketRegressionDetected, "remote", "bucket")
+}
+
+// isRegression reports whether current dropped from previous by more than threshold percent
+func isRegression(previous, current int64, threshold float64) bool {
+	if previous <= 0 || current >= previous {
+		return false
+	}
+	return float64(previous-current)/float64(previous)*100 > threshold
+}
+
+// checkRegression compares the bucket's count with its last good count and records whether either
+// its file count or size regressed by more than threshold percent
+func checkRegression(remote, bucketName string, files, size int64, threshold float64) bool {
+	regressed := false
+	state.bucket(remote, bucketName, func(b *bucketState) {
+		regressed = b.sized && (isRegression(b.lastFiles, files, threshold) || isRegression(b.lastSize, size, threshold))
+	})
+	value := 0.0
+	if regressed {
+		value = 1
+	}
+	bucketRegressionDetected.WithLabelValues(remote, bucketName).Set(value)
+	return regressed
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/walk"
+)
+
+var (
+	bucketEstimatedSize = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_bucket_estimated_size_bytes",
+			Help: "Estimated total size in bytes for a bucket, extrapolated from a sample of its prefixes",
+		},
+		[]string{"remote", "bucket"},
+	)
+	bucketEstimatedFileCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_bucket_estimated_file_count",
+			Help: "Estimated file count for a bucket, extrapolated from a sample of its prefixes",
+		},
+		[]string{"remote", "bucket"},
+	)
+	bucketEstimatedSizeRelativeError = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_bucket_estimated_size_relative_error",
+			Help: "Relative standard error of a bucket's estimated size, 0 when every prefix was counted",
+		},
+		[]string{"remote", "bucket"},
+	)
+	remoteSamplingActive = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_remote_sampling_active",
+			Help: "Whether a remote's buckets were estimated from samples instead of counted in the last scrape",
+		},
+		[]string{"remote"},
+	)
+)
+
+func init() {
+	mustRegisterRemoteVec(bucketEstimatedSize, "remote", "bucket")
+	mustRegisterRemoteVec(bucketEstimatedFileCount, "remote", "bucket")
+	mustRegisterRemoteVec(bucketEstimatedSizeRelativeError, "remote", "bucket")
+	mustRegisterRemoteVec(remoteSamplingActive, "remote")
+}
+
+// sizeEstimate is the extrapolated size of a bucket
+type sizeEstimate struct {
+	files float64
+	size  float64
+	// relativeError is the standard error of size divided by size
+	relativeError float64
+}
+
+// estimateBucket estimates the bucket's size by fully counting a random sample of up to
+// samplePrefixes of its top-level prefixes and extrapolating their mean to every prefix. Objects at
+// the top level are counted exactly. The estimate is only as good as the prefixes are similar in
+// size, which is reflected in the relative error
+func estimateBucket(ctx context.Context, bucketFs fs.Fs, samplePrefixes int) (*sizeEstimate, error) {
+	entries, err := bucketFs.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	estimate := &sizeEstimate{}
+	prefixes := []string{}
+	for _, entry := range entries {
+		switch e := entry.(type) {
+		case fs.Object:
+			estimate.files++
+			estimate.size += float64(max(e.Size(), 0))
+		case fs.Directory:
+			prefixes = append(prefixes, e.Remote())
+		}
+	}
+	if len(prefixes) == 0 {
+		return estimate, nil
+	}
+
+	rand.Shuffle(len(prefixes), func(i, j int) {
+		prefixes[i], prefixes[j] = prefixes[j], prefixes[i]
+	})
+	sample := prefixes[:min(samplePrefixes, len(prefixes))]
+	sizes := make([]float64, len(sample))
+	var sampleFiles, sampleSize float64
+	for i, prefix := range sample {
+		err := walk.ListR(ctx, bucketFs, prefix, false, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+			entries.ForObject(func(o fs.Object) {
+				sampleFiles++
+				sizes[i] += float64(max(o.Size(), 0))
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sampleSize += sizes[i]
+	}
+
+	n, k := float64(len(prefixes)), float64(len(sample))
+	meanSize := sampleSize / k
+	estimate.files += n * sampleFiles / k
+	estimate.size += n * meanSize
+	if k == n || estimate.size == 0 {
+		return estimate, nil
+	}
+	// Standard error of the extrapolated total, with the finite population correction
+	var variance float64
+	for _, size := range sizes {
+		variance += (size - meanSize) * (size - meanSize)
+	}
+	variance /= k - 1
+	standardError := n * math.Sqrt(variance/k*(1-k/n))
+	estimate.relativeError = standardError / estimate.size
+	return estimate, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// remoteVec is a metric vector labelled by remote
+type remoteVec interface {
+	prometheus.Collector
+	DeletePartialMatch(labels prometheus.Labels) int
+}
+
+var (
+	// remoteVecs are every registered metric vector labelled by remote
+	remoteVecs []remoteVec
+	// remoteVecLabels are the label names of the registered remoteVecs
+	remoteVecLabels = map[string]bool{}
+)
+
+// mustRegisterRemoteVec registers vec, labelled by labels, with the default registry and records it
+// so the series of a remote can be removed from every metric at once
+func mustRegisterRemoteVec(vec remoteVec, labels ...string) {
+	prometheus.MustRegister(vec)
+	remoteVecs = append(remoteVecs, vec)
+	for _, label := range labels {
+		remoteVecLabels[label] = true
+	}
+}
+
+// deleteRemoteSeries removes every series of the remote from the registered metrics
+func deleteRemoteSeries(remote string) {
+	for _, vec := range remoteVecs {
+		vec.DeletePartialMatch(prometheus.Labels{"remote": remote})
+	}
+}
+
+// deleteBucketSeries removes every series of the remote's bucket from the registered metrics
+func deleteBucketSeries(remote, bucket string) {
+	for _, vec := range remoteVecs {
+		vec.DeletePartialMatch(prometheus.Labels{"remote": remote, "bucket": bucket})
+	}
+}
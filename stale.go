@@ -0,0 +1,45 @@
+package main
+
+import (
+	"slices"
+
+	"github.com/sirupsen/logrus"
+)
+
+// pruneStaleBuckets deletes the series and state of the remote's buckets that have been missing
+// from opts.staleSeriesGraceCycles consecutive successful listings, given the buckets listed this
+// time. Requiring several cycles keeps a listing that transiently returns a subset of the buckets
+// from making their series flap
+func pruneStaleBuckets(remote string, listed []string, opts *options) {
+	stale := []string{}
+	state.mu.Lock()
+	// Buckets that were never counted, e.g. only estimated, still need tracking
+	for _, bucket := range listed {
+		key := bucketKey{remote: remote, bucket: bucket}
+		if state.buckets[key] == nil {
+			state.buckets[key] = &bucketState{}
+		}
+	}
+	for key, b := range state.buckets {
+		if key.remote != remote {
+			continue
+		}
+		if slices.Contains(listed, key.bucket) {
+			b.absentCycles = 0
+			continue
+		}
+		b.absentCycles++
+		if b.absentCycles >= opts.staleSeriesGraceCycles {
+			delete(state.buckets, key)
+			stale = append(stale, key.bucket)
+		}
+	}
+	state.mu.Unlock()
+	for _, bucket := range stale {
+		logrus.WithFields(logrus.Fields{
+			"remote": remote,
+			"bucket": bucket,
+		}).Info("deleting the series of a bucket no longer listed")
+		deleteBucketSeries(remote, bucket)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"time"
+)
+
+// stalestRemotesFirst returns the remotes ordered by the time of their last successful scrape,
+// oldest first, with remotes that never succeeded ahead of them all
+func stalestRemotesFirst(remotes []remoteConfig) []remoteConfig {
+	lastSuccess := map[string]time.Time{}
+	for _, remote := range remotes {
+		state.remote(remote.Name, func(r *remoteState) {
+			lastSuccess[remote.Name] = r.lastSuccessTime
+		})
+	}
+	ordered := slices.Clone(remotes)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return lastSuccess[ordered[i].Name].Before(lastSuccess[ordered[j].Name])
+	})
+	return ordered
+}
+
+// stalestBucketsFirst orders the remote's bucket paths and their names by the time each bucket was
+// last scraped successfully, oldest first, with buckets that never succeeded ahead of them all
+func stalestBucketsFirst(remote string, bucketPaths, bucketNames []string) {
+	lastSuccess := make([]time.Time, len(bucketNames))
+	indexes := make([]int, len(bucketNames))
+	for i, name := range bucketNames {
+		indexes[i] = i
+		state.bucket(remote, name, func(b *bucketState) {
+			lastSuccess[i] = b.lastSuccessTime
+		})
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return lastSuccess[indexes[i]].Before(lastSuccess[indexes[j]])
+	})
+	paths, names := slices.Clone(bucketPaths), slices.Clone(bucketNames)
+	for i, index := range indexes {
+		bucketPaths[i], bucketNames[i] = paths[index], names[index]
+	}
+}
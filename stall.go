@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+var bucketCountStalled = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "rclone_bucket_count_stalled_total",
+		Help: "Number of bucket counts run past their timeout that were aborted after counting no objects for -stall-timeout",
+	},
+	[]string{"remote", "bucket"},
+)
+
+func init() {
+	mustRegisterRemoteVec(bucketCountStalled, "remote", "bucket")
+}
+
+var (
+	// errCountStalled is the cause of a count being aborted for making no progress past its deadline
+	errCountStalled = errors.New("count stopped making progress past its timeout")
+	// errCountTimeoutMax is the cause of a count being aborted for running for -stall-timeout-max
+	errCountTimeoutMax = errors.New("count reached -stall-timeout-max")
+)
+
+// stallWatch counts the objects listed by a count, so it can tell whether the count is progressing
+type stallWatch struct {
+	objects atomic.Int64
+}
+
+type stallWatchKey struct{}
+
+// stallWatchFrom returns the stallWatch of the count run with ctx, nil if it isn't watched
+func stallWatchFrom(ctx context.Context) *stallWatch {
+	watch, _ := ctx.Value(stallWatchKey{}).(*stallWatch)
+	return watch
+}
+
+// progress records an object being listed. A nil watch ignores it
+func (w *stallWatch) progress() {
+	if w != nil {
+		w.objects.Add(1)
+	}
+}
+
+// withStallTimeout returns a context for a count that outlives ctx's deadline while the count keeps
+// listing objects. Past the deadline, it's cancelled once no object has been listed for
+// opts.stallTimeout, or once opts.stallTimeoutMax has passed since the count started. ctx being
+// cancelled for any other reason, e.g. shutdown, still cancels it
+func withStallTimeout(ctx context.Context, opts *options, contextLogger *logrus.Entry) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return ctx, func() {}
+	}
+	countCtx, cancel := context.WithCancelCause(context.WithoutCancel(ctx))
+	countCtx, cancelMax := context.WithTimeoutCause(countCtx, opts.stallTimeoutMax, errCountTimeoutMax)
+	watch := &stallWatch{}
+	countCtx = context.WithValue(countCtx, stallWatchKey{}, watch)
+	stopParent := context.AfterFunc(ctx, func() {
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			cancel(context.Cause(ctx))
+		}
+	})
+	go func() {
+		select {
+		case <-opts.clock.After(deadline.Sub(opts.clock.Now())):
+		case <-countCtx.Done():
+			return
+		}
+		// Counts that are still listing objects at the deadline get another stall timeout each
+		// time they list some more
+		objects := watch.objects.Load()
+		contextLogger.WithField("objects", objects).Info("count still running at its timeout, extending while it makes progress")
+		for {
+			select {
+			case <-opts.clock.After(opts.stallTimeout):
+			case <-countCtx.Done():
+				return
+			}
+			listed := watch.objects.Load()
+			if listed == objects {
+				cancel(errCountStalled)
+				return
+			}
+			objects = listed
+		}
+	}()
+	return countCtx, func() {
+		stopParent()
+		cancelMax()
+		cancel(context.Canceled)
+	}
+}
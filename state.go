@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// bucketKey identifies a bucket within a remote
+type bucketKey struct {
+	remote string
+	bucket string
+}
+
+// bucketState is what the exporter remembers about a bucket between scrapes
+type bucketState struct {
+	// fileCounts holds the file counts of the most recent scrapes, oldest first
+	fileCounts []int64
+	// lastSize is the bucket's size in its last successful count, if sized
+	lastSize int64
+	// lastFiles is the bucket's file count in its last successful count, if sized
+	lastFiles int64
+	// sized is whether the bucket has been counted successfully
+	sized bool
+	// lastCountTime is when the bucket's last successful count finished, if sized
+	lastCountTime time.Time
+	// lastSuccessTime is when the bucket was last scraped successfully
+	lastSuccessTime time.Time
+	// absentCycles is the number of consecutive successful listings of the remote the bucket has
+	// been missing from
+	absentCycles int
+	// countDurations holds how long the most recent successful counts took, oldest first
+	countDurations []time.Duration
+}
+
+// remoteState is what the exporter remembers about a remote between scrapes
+type remoteState struct {
+	// lastSuccess is whether the remote's last scrape succeeded
+	lastSuccess bool
+	// lastSuccessTime is when the remote's last successful scrape finished
+	lastSuccessTime time.Time
+	// failingSince is when the first scrape of the remote's current streak of failures started,
+	// zero while it succeeds
+	failingSince time.Time
+	// lastTimedOut is whether the remote's last scrape hit its deadline
+	lastTimedOut bool
+	// outcomes holds whether each of the most recent scrapes succeeded, oldest first
+	outcomes []bool
+	// lastStart is when the remote's last scrape started
+	lastStart time.Time
+	// firstAttempt is when the remote's first scrape started
+	firstAttempt time.Time
+	// everSucceeded is whether any scrape of the remote has succeeded since startup
+	everSucceeded bool
+	// scrapeStats is rclone's accounting of the remote's most recent scrape
+	scrapeStats *scrapeStats
+	// retryTime is the time lost to retries so far in the remote's current scrape
+	retryTime time.Duration
+	// rateLimited is whether the remote rate limited the exporter since its last scrape finished
+	rateLimited bool
+	// rateLimitStreak is the number of consecutive scrapes the remote rate limited
+	rateLimitStreak int
+	// cooldownUntil is when the remote's rate limit cooldown ends, zero when not cooling down
+	cooldownUntil time.Time
+}
+
+// exporterState holds what the exporter remembers between scrapes
+type exporterState struct {
+	mu      sync.Mutex
+	remotes map[string]*remoteState
+	buckets map[bucketKey]*bucketState
+}
+
+// state is shared by every scrape
+var state = &exporterState{
+	remotes: map[string]*remoteState{},
+	buckets: map[bucketKey]*bucketState{},
+}
+
+// remote calls fn with the state of the remote while holding the state lock, creating the state on
+// first use
+func (s *exporterState) remote(remote string, fn func(*remoteState)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.remotes[remote]
+	if r == nil {
+		r = &remoteState{}
+		s.remotes[remote] = r
+	}
+	fn(r)
+}
+
+// bucket calls fn with the state of the bucket while holding the state lock, creating the state on
+// first use
+func (s *exporterState) bucket(remote, bucket string, fn func(*bucketState)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := bucketKey{remote: remote, bucket: bucket}
+	b := s.buckets[key]
+	if b == nil {
+		b = &bucketState{}
+		s.buckets[key] = b
+	}
+	fn(b)
+}
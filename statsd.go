@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// statsdMaxPacket keeps each StatsD datagram within a typical network MTU
+const statsdMaxPacket = 1432
+
+// statsdMetrics are the metric families forwarded to StatsD after each cycle
+var statsdMetrics = map[string]bool{
+	"rclone_bucket_size_bytes":           true,
+	"rclone_bucket_file_count":           true,
+	"rclone_bucket_estimated_size_bytes": true,
+	"rclone_bucket_estimated_file_count": true,
+	"rclone_path_size_bytes":             true,
+	"rclone_path_file_count":             true,
+}
+
+// statsdTagFormat controls how labels are encoded in StatsD lines
+type statsdTagFormat string
+
+const (
+	// statsdTagsDogStatsD appends labels as DogStatsD tags, e.g. "name:1|g|#remote:b2:,bucket:b"
+	statsdTagsDogStatsD statsdTagFormat = "dogstatsd"
+	// statsdTagsInflux appends labels to the name InfluxDB style, e.g. "name,remote=b2:,bucket=b:1|g"
+	statsdTagsInflux statsdTagFormat = "influx"
+	// statsdTagsNone joins the label values onto the name for plain StatsD, e.g. "name.b2.b:1|g"
+	statsdTagsNone statsdTagFormat = "none"
+)
+
+// parseStatsdTagFormat validates s as a statsdTagFormat
+func parseStatsdTagFormat(s string) (statsdTagFormat, error) {
+	switch format := statsdTagFormat(s); format {
+	case statsdTagsDogStatsD, statsdTagsInflux, statsdTagsNone:
+		return format, nil
+	}
+	return "", fmt.Errorf("StatsD tag format %q must be one of %q, %q or %q", s, statsdTagsDogStatsD, statsdTagsInflux, statsdTagsNone)
+}
+
+// statsdSanitizer replaces the characters that delimit StatsD lines, tags or plain names
+var statsdSanitizer = strings.NewReplacer(":", "_", "|", "_", ",", "_", "#", "_", "=", "_", " ", "_", "\n", "_", ".", "_")
+
+// statsdTagSanitizer is statsdSanitizer for DogStatsD tag values, which may contain colons and dots
+var statsdTagSanitizer = strings.NewReplacer("|", "_", ",", "_", "#", "_", "\n", "_")
+
+// statsdClient sends the exporter's gauges to a StatsD server over UDP
+type statsdClient struct {
+	conn   net.Conn
+	format statsdTagFormat
+}
+
+// newStatsdClient creates a client sending to the StatsD server at addr
+func newStatsdClient(addr string, format statsdTagFormat) (*statsdClient, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &statsdClient{conn: conn, format: format}, nil
+}
+
+// line formats a single gauge sample
+func (c *statsdClient) line(name string, labels [][2]string, value float64) string {
+	formatted := strconv.FormatFloat(value, 'f', -1, 64)
+	var b strings.Builder
+	b.WriteString(name)
+	switch c.format {
+	case statsdTagsDogStatsD:
+		b.WriteString(":" + formatted + "|g")
+		for i, label := range labels {
+			if i == 0 {
+				b.WriteString("|#")
+			} else {
+				b.WriteString(",")
+			}
+			b.WriteString(label[0] + ":" + statsdTagSanitizer.Replace(label[1]))
+		}
+		return b.String()
+	case statsdTagsInflux:
+		for _, label := range labels {
+			b.WriteString("," + label[0] + "=" + statsdSanitizer.Replace(label[1]))
+		}
+	default:
+		for _, label := range labels {
+			b.WriteString("." + statsdSanitizer.Replace(label[1]))
+		}
+	}
+	b.WriteString(":" + formatted + "|g")
+	return b.String()
+}
+
+// send gathers the forwarded metric families from gatherer and sends them as gauges, packing as
+// many lines into each datagram as fit
+func (c *statsdClient) send(gatherer prometheus.Gatherer) error {
+	families, err := gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	var packet strings.Builder
+	flush := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := c.conn.Write([]byte(packet.String()))
+		packet.Reset()
+		return err
+	}
+	for _, family := range families {
+		if !statsdMetrics[family.GetName()] {
+			continue
+		}
+		for _, metric := range family.Metric {
+			labels := make([][2]string, 0, len(metric.Label))
+			for _, label := range metric.Label {
+				labels = append(labels, [2]string{label.GetName(), label.GetValue()})
+			}
+			line := c.line(family.GetName(), labels, metric.GetGauge().GetValue())
+			if packet.Len() > 0 && packet.Len()+1+len(line) > statsdMaxPacket {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+			if packet.Len() > 0 {
+				packet.WriteString("\n")
+			}
+			packet.WriteString(line)
+		}
+	}
+	return flush()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rclone/rclone/fs"
+)
+
+var (
+	bucketSizeByStorageClass = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_bucket_size_bytes_by_storage_class",
+			Help: "Total size in bytes of the objects in a bucket in a storage class",
+		},
+		[]string{"remote", "bucket", "storage_class"},
+	)
+	bucketFileCountByStorageClass = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_bucket_file_count_by_storage_class",
+			Help: "File count of the objects in a bucket in a storage class",
+		},
+		[]string{"remote", "bucket", "storage_class"},
+	)
+)
+
+func init() {
+	mustRegisterRemoteVec(bucketSizeByStorageClass, "remote", "bucket", "storage_class")
+	mustRegisterRemoteVec(bucketFileCountByStorageClass, "remote", "bucket", "storage_class")
+}
+
+// parseStorageClasses parses a comma separated list of storage classes such as "STANDARD,GLACIER"
+func parseStorageClasses(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	classes := []string{}
+	for _, class := range strings.Split(s, ",") {
+		class = strings.TrimSpace(class)
+		if class == "" {
+			return nil, fmt.Errorf("empty storage class in %q", s)
+		}
+		classes = append(classes, class)
+	}
+	return classes, nil
+}
+
+// storageClass returns the storage class of the object, or "" if its backend doesn't report one
+func storageClass(o fs.Object) string {
+	tierer, ok := o.(fs.GetTierer)
+	if !ok {
+		return ""
+	}
+	return tierer.GetTier()
+}
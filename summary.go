@@ -0,0 +1,190 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	remoteSize = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_remote_size_bytes",
+			Help: "Total size in bytes of the buckets of a remote configured with summary_only",
+		},
+		[]string{"remote"},
+	)
+	remoteFileCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rclone_remote_file_count",
+			Help: "Total file count of the buckets of a remote configured with summary_only",
+		},
+		[]string{"remote"},
+	)
+	remoteBucketSizes = newBucketSizeHistograms()
+)
+
+func init() {
+	mustRegisterRemoteVec(remoteSize, "remote")
+	mustRegisterRemoteVec(remoteFileCount, "remote")
+	mustRegisterRemoteVec(remoteBucketSizes, "remote")
+}
+
+// bucketSizeBuckets are the upper bounds of the bucket size histogram, from 1 MiB to 4 TiB
+var bucketSizeBuckets = prometheus.ExponentialBuckets(1<<20, 4, 12)
+
+// bucketSizeHistograms exports a histogram of the current sizes of each summary_only remote's
+// buckets. Unlike a prometheus.Histogram it doesn't accumulate across scrapes, each scrape of the
+// remote replaces its observations
+type bucketSizeHistograms struct {
+	desc  *prometheus.Desc
+	mu    sync.Mutex
+	sizes map[string][]int64
+}
+
+func newBucketSizeHistograms() *bucketSizeHistograms {
+	return &bucketSizeHistograms{
+		desc: prometheus.NewDesc(
+			"rclone_remote_bucket_size_bytes",
+			"Distribution of the sizes in bytes of the buckets of a remote configured with summary_only",
+			[]string{"remote"}, nil,
+		),
+		sizes: map[string][]int64{},
+	}
+}
+
+// set replaces the remote's bucket sizes
+func (h *bucketSizeHistograms) set(remote string, sizes []int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sizes[remote] = sizes
+}
+
+// Describe implements prometheus.Collector
+func (h *bucketSizeHistograms) Describe(ch chan<- *prometheus.Desc) {
+	ch <- h.desc
+}
+
+// Collect implements prometheus.Collector
+func (h *bucketSizeHistograms) Collect(ch chan<- prometheus.Metric) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for remote, sizes := range h.sizes {
+		counts := make(map[float64]uint64, len(bucketSizeBuckets))
+		sum := 0.0
+		for _, size := range sizes {
+			sum += float64(size)
+			for _, bound := range bucketSizeBuckets {
+				if float64(size) <= bound {
+					counts[bound]++
+				}
+			}
+		}
+		ch <- prometheus.MustNewConstHistogram(h.desc, uint64(len(sizes)), sum, counts, remote)
+	}
+}
+
+// DeletePartialMatch implements remoteVec. The histograms only have a remote label, so labels
+// naming a bucket never match
+func (h *bucketSizeHistograms) DeletePartialMatch(labels prometheus.Labels) int {
+	remote, ok := labels["remote"]
+	if !ok || len(labels) > 1 {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.sizes[remote]; !ok {
+		return 0
+	}
+	delete(h.sizes, remote)
+	return 1
+}
+
+// updateRemoteSummary exports the totals and size distribution of the remote's buckets from their
+// last good counts. Sampled buckets aren't counted, so they're left out
+func updateRemoteSummary(remote string, bucketNames []string) {
+	var files, size int64
+	sizes := make([]int64, 0, len(bucketNames))
+	for _, bucketName := range bucketNames {
+		state.bucket(remote, bucketName, func(b *bucketState) {
+			if !b.sized {
+				return
+			}
+			files += b.lastFiles
+			size += b.lastSize
+			sizes = append(sizes, b.lastSize)
+		})
+	}
+	remoteSize.WithLabelValues(remote).Set(float64(size))
+	remoteFileCount.WithLabelValues(remote).Set(float64(files))
+	remoteBucketSizes.set(remote, sizes)
+}
+
+// deleteRemoteSummary removes the summary of a remote that's no longer configured with summary_only
+func deleteRemoteSummary(remote string) {
+	remoteSize.DeleteLabelValues(remote)
+	remoteFileCount.DeleteLabelValues(remote)
+	remoteBucketSizes.DeletePartialMatch(prometheus.Labels{"remote": remote})
+}
+
+// summaryOnlyRemotes are the remotes configured with summary_only, swapped when the config is
+// reloaded
+var summaryOnlyRemotes atomic.Pointer[map[string]bool]
+
+// updateSummaryOnlyRemotes records which of the remotes are configured with summary_only
+func updateSummaryOnlyRemotes(remotes []remoteConfig) {
+	summaryOnly := map[string]bool{}
+	for _, remote := range remotes {
+		if remote.SummaryOnly {
+			summaryOnly[remote.Name] = true
+		}
+	}
+	summaryOnlyRemotes.Store(&summaryOnly)
+}
+
+// summaryOnlyGatherer leaves the series with a bucket label of summary_only remotes out of the
+// metrics gathered from the wrapped Gatherer. They're still collected, so the remote's summary and
+// checks relying on the last counts keep working, just never exported
+type summaryOnlyGatherer struct {
+	prometheus.Gatherer
+}
+
+// Gather implements prometheus.Gatherer
+func (g summaryOnlyGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	summaryOnly := summaryOnlyRemotes.Load()
+	if summaryOnly == nil || len(*summaryOnly) == 0 {
+		return families, err
+	}
+	kept := families[:0]
+	for _, family := range families {
+		metrics := family.Metric[:0]
+		for _, metric := range family.Metric {
+			if !isSummarizedBucketSeries(metric, *summaryOnly) {
+				metrics = append(metrics, metric)
+			}
+		}
+		family.Metric = metrics
+		// Families without any metric left can't be encoded
+		if len(metrics) > 0 {
+			kept = append(kept, family)
+		}
+	}
+	return kept, err
+}
+
+// isSummarizedBucketSeries reports whether the metric is a bucket series of a summary_only remote
+func isSummarizedBucketSeries(metric *dto.Metric, summaryOnly map[string]bool) bool {
+	remote, bucket := "", false
+	for _, label := range metric.Label {
+		switch label.GetName() {
+		case "remote":
+			remote = label.GetValue()
+		case "bucket":
+			bucket = true
+		}
+	}
+	return bucket && summaryOnly[remote]
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	remoteTokenRefreshes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rclone_remote_token_refresh_total",
+			Help: "Total number of OAuth tokens refreshed and stored for a remote's config section",
+		},
+		[]string{"remote"},
+	)
+	remoteTokenRefreshErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rclone_remote_token_refresh_errors_total",
+			Help: "Total number of scrape errors of a remote caused by failing to refresh its OAuth token",
+		},
+		[]string{"remote"},
+	)
+)
+
+func init() {
+	mustRegisterRemoteVec(remoteTokenRefreshes, "remote")
+	mustRegisterRemoteVec(remoteTokenRefreshErrors, "remote")
+}
+
+// tokenRefreshErrors are fragments of the errors rclone's OAuth token source returns when it can't
+// refresh a token. They're only wrapped into strings by the HTTP client, so they're matched as text
+var tokenRefreshErrors = []string{
+	"couldn't fetch token",
+	"couldn't store token",
+	"token expired and there's no refresh token",
+	"empty token found",
+}
+
+// isTokenRefreshError reports whether err was caused by failing to refresh an OAuth token
+func isTokenRefreshError(err error) bool {
+	if err == nil {
+		return false
+	}
+	message := err.Error()
+	for _, fragment := range tokenRefreshErrors {
+		if strings.Contains(message, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordRemoteError counts a failed operation against the remote, also counting it as a token
+// refresh error when that's what caused it, and noting when the remote is rate limiting
+func recordRemoteError(remote, operation string, err error) {
+	remoteErrors.WithLabelValues(remote, operation).Inc()
+	if isTokenRefreshError(err) {
+		remoteTokenRefreshErrors.WithLabelValues(remote).Inc()
+	}
+	if isRateLimitError(err) {
+		state.remote(remote, func(r *remoteState) {
+			r.rateLimited = true
+		})
+	}
+}
+
+// tokenCountingStorage wraps rclone's config storage to count refreshed OAuth tokens, which rclone's
+// token source stores in the remote's config section as soon as it gets them
+type tokenCountingStorage struct {
+	config.Storage
+}
+
+// countTokenRefreshes installs tokenCountingStorage over the installed config storage. rclone keeps
+// its in-memory storage when it has no config file path, e.g. when it finds neither a home nor a
+// config directory, so refreshes can't be counted then and are left unexported
+func countTokenRefreshes() {
+	if config.GetConfigPath() == "" {
+		logrus.Info("no rclone config file, rclone_remote_token_refresh_total won't be exported")
+		return
+	}
+	config.SetData(tokenCountingStorage{Storage: config.Data()})
+}
+
+// SetValue implements config.Storage
+func (s tokenCountingStorage) SetValue(section, key, value string) {
+	if key == "token" {
+		remoteTokenRefreshes.WithLabelValues(section + ":").Inc()
+	}
+	s.Storage.SetValue(section, key, value)
+}
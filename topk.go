@@ -0,0 +1,130 @@
+package main
+
+import (
+	"container/heap"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var bucketSizeByMetadataError = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "rclone_bucket_size_bytes_by_metadata_max_error",
+		Help: "Upper bound in bytes on how much any exported per metadata value size of a bucket is overestimated, 0 when exact",
+	},
+	[]string{"remote", "bucket"},
+)
+
+func init() {
+	mustRegisterRemoteVec(bucketSizeByMetadataError, "remote", "bucket")
+}
+
+// trackedGroup is a group tracked by topGroupTracker along with how much of it may belong to
+// values it evicted
+type trackedGroup struct {
+	value string
+	group objectGroup
+	// errSize bounds how much of the group's size was inherited from evicted values
+	errSize int64
+	// index is the group's position in the heap
+	index int
+}
+
+// groupHeap is a min-heap of tracked groups by size
+type groupHeap []*trackedGroup
+
+func (h groupHeap) Len() int           { return len(h) }
+func (h groupHeap) Less(i, j int) bool { return h[i].group.size < h[j].group.size }
+func (h groupHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *groupHeap) Push(x any) {
+	g := x.(*trackedGroup)
+	g.index = len(*h)
+	*h = append(*h, g)
+}
+
+func (h *groupHeap) Pop() any {
+	old := *h
+	g := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return g
+}
+
+// topGroupTracker groups objects by value in bounded memory, tracking at most capacity values
+// with the Space-Saving algorithm: once full, a new value replaces the smallest tracked group and
+// inherits its totals as error. Groups at least as large as the smallest tracked one are always
+// tracked, so the largest groups are kept while the long tail is folded together
+type topGroupTracker struct {
+	// capacity caps the number of tracked values, 0 is unbounded
+	capacity int
+	groups   map[string]*trackedGroup
+	heap     groupHeap
+	// total groups every object added
+	total objectGroup
+	// evicted is whether any value was evicted, making the tracked groups approximate
+	evicted bool
+}
+
+func newTopGroupTracker(capacity int) *topGroupTracker {
+	return &topGroupTracker{capacity: capacity, groups: map[string]*trackedGroup{}}
+}
+
+// add adds an object of the given size to the value's group
+func (t *topGroupTracker) add(value string, size int64) {
+	t.total.count++
+	t.total.size += size
+	if g, ok := t.groups[value]; ok {
+		g.group.count++
+		g.group.size += size
+		heap.Fix(&t.heap, g.index)
+		return
+	}
+	if t.capacity <= 0 || len(t.groups) < t.capacity {
+		g := &trackedGroup{value: value, group: objectGroup{count: 1, size: size}}
+		t.groups[value] = g
+		heap.Push(&t.heap, g)
+		return
+	}
+	smallest := t.heap[0]
+	delete(t.groups, smallest.value)
+	t.evicted = true
+	smallest.value = value
+	smallest.errSize = smallest.group.size
+	smallest.group.count++
+	smallest.group.size += size
+	t.groups[value] = smallest
+	heap.Fix(&t.heap, 0)
+}
+
+// top returns the n largest groups by size, 0 returning every tracked group, with the rest merged
+// into a single "(other)" group to cap the cardinality of the resulting metrics. It also returns
+// the most any returned group's size may be overestimated by
+func (t *topGroupTracker) top(n int) (map[string]*objectGroup, int64) {
+	tracked := make([]*trackedGroup, len(t.heap))
+	copy(tracked, t.heap)
+	sort.Slice(tracked, func(i, j int) bool {
+		return tracked[i].group.size > tracked[j].group.size
+	})
+	if n <= 0 || n > len(tracked) {
+		n = len(tracked)
+	}
+	top := make(map[string]*objectGroup, n+1)
+	var maxErr int64
+	other := t.total
+	for _, g := range tracked[:n] {
+		group := g.group
+		top[g.value] = &group
+		maxErr = max(maxErr, g.errSize)
+		other.count -= group.count
+		other.size -= group.size
+	}
+	if n < len(tracked) || t.evicted {
+		// Overestimated groups can leave less than nothing for the rest
+		top[metadataValueOther] = &objectGroup{count: max(other.count, 0), size: max(other.size, 0)}
+	}
+	return top, maxErr
+}
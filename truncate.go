@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sirupsen/logrus"
+)
+
+var metricsTruncated = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "rclone_exporter_metrics_truncated",
+		Help: "Whether metric families were left out of the last /metrics response to keep it within -metrics-max-bytes",
+	},
+)
+
+// truncationRegistry holds the truncation metric, which is always written last so it's never
+// itself truncated
+var truncationRegistry = prometheus.NewRegistry()
+
+func init() {
+	truncationRegistry.MustRegister(metricsTruncated)
+}
+
+// cappedMetricsHandler serves the metrics gathered from gatherer in the text format, leaving out
+// whole metric families that would take the response over maxBytes so what's left is still valid,
+// followed by the truncation metric gathered from truncation. It's a safety valve against
+// misconfigured high cardinality collectors overwhelming Prometheus
+func cappedMetricsHandler(gatherer, truncation prometheus.Gatherer, maxBytes int) http.Handler {
+	format := expfmt.NewFormat(expfmt.TypeTextPlain)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		families, err := gatherer.Gather()
+		if err != nil {
+			logrus.WithError(err).Error("failed gathering metrics")
+		}
+		var body, encoded bytes.Buffer
+		// Leave room for the truncation metric, whose size doesn't depend on its value
+		truncationFamilies, err := truncation.Gather()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, family := range truncationFamilies {
+			if _, err := expfmt.MetricFamilyToText(&encoded, family); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		budget := maxBytes - encoded.Len()
+		omitted := 0
+		for _, family := range families {
+			encoded.Reset()
+			if _, err := expfmt.MetricFamilyToText(&encoded, family); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if body.Len()+encoded.Len() > budget {
+				omitted++
+				continue
+			}
+			body.Write(encoded.Bytes())
+		}
+		truncated := 0.0
+		if omitted > 0 {
+			truncated = 1
+			logrus.WithFields(logrus.Fields{
+				"omitted":   omitted,
+				"max_bytes": maxBytes,
+			}).Warn("metrics response too large, leaving out metric families")
+		}
+		metricsTruncated.Set(truncated)
+		truncationFamilies, err = truncation.Gather()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, family := range truncationFamilies {
+			if _, err := expfmt.MetricFamilyToText(&body, family); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", string(format))
+		w.Write(body.Bytes())
+	})
+}
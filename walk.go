@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/walk"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// metadataValueNone groups objects that don't carry the metadata key
+	metadataValueNone = "(none)"
+	// metadataValueOther groups every value that didn't make the top-N
+	metadataValueOther = "(other)"
+)
+
+// unknownSizePolicy controls how objects whose backend reports a size of -1 are treated
+type unknownSizePolicy string
+
+const (
+	// unknownSizeSkip leaves objects with an unknown size out of the sizes, while still counting them
+	unknownSizeSkip unknownSizePolicy = "skip"
+	// unknownSizeZero counts objects with an unknown size as 0 bytes
+	unknownSizeZero unknownSizePolicy = "zero"
+	// unknownSizeError fails the bucket when an object with an unknown size is found
+	unknownSizeError unknownSizePolicy = "error"
+)
+
+// parseUnknownSizePolicy validates s as an unknownSizePolicy
+func parseUnknownSizePolicy(s string) (unknownSizePolicy, error) {
+	switch policy := unknownSizePolicy(s); policy {
+	case unknownSizeSkip, unknownSizeZero, unknownSizeError:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown size policy %q must be one of %q, %q or %q", s, unknownSizeSkip, unknownSizeZero, unknownSizeError)
+}
+
+// ageTier is a range of object ages, from the previous tier's max up to max
+type ageTier struct {
+	// label names the tier in metrics, e.g. "7d-30d"
+	label string
+	// max is the tier's exclusive upper bound, 0 for the last, unbounded, tier
+	max time.Duration
+}
+
+// parseAgeTiers parses a comma separated list of ascending age boundaries such as "7d,30d,90d" into
+// the tiers between them, e.g. "<7d", "7d-30d", "30d-90d" and ">=90d"
+func parseAgeTiers(s string) ([]ageTier, error) {
+	if s == "" {
+		return nil, nil
+	}
+	tiers := []ageTier{}
+	previous := ""
+	var previousMax time.Duration
+	for _, boundary := range strings.Split(s, ",") {
+		boundary = strings.TrimSpace(boundary)
+		age, err := fs.ParseDuration(boundary)
+		if err != nil {
+			return nil, err
+		}
+		if age <= previousMax {
+			return nil, fmt.Errorf("age boundary %q must be greater than the previous one", boundary)
+		}
+		label := "<" + boundary
+		if previous != "" {
+			label = previous + "-" + boundary
+		}
+		tiers = append(tiers, ageTier{label: label, max: age})
+		previous, previousMax = boundary, age
+	}
+	return append(tiers, ageTier{label: ">=" + previous}), nil
+}
+
+// objectGroup accumulates the object count and total size of a group of objects
+type objectGroup struct {
+	count int64
+	size  int64
+}
+
+// welford accumulates the mean and variance of a stream of values in a single pass using Welford's
+// algorithm, which stays numerically stable for large counts
+type welford struct {
+	n    int64
+	mean float64
+	m2   float64
+}
+
+// add adds x to the stream
+func (w *welford) add(x float64) {
+	w.n++
+	delta := x - w.mean
+	w.mean += delta / float64(w.n)
+	w.m2 += delta * (x - w.mean)
+}
+
+// stddev returns the population standard deviation of the values added so far
+func (w *welford) stddev() float64 {
+	if w.n == 0 {
+		return 0
+	}
+	return math.Sqrt(w.m2 / float64(w.n))
+}
+
+// bucketWalk holds the results of walking every object in a bucket
+type bucketWalk struct {
+	// byMetadata groups objects by the value of the configured metadata key
+	byMetadata map[string]*objectGroup
+	// byMetadataMaxError bounds how much any group in byMetadata has its size overestimated by
+	byMetadataMaxError int64
+	// byAge counts objects per age tier, in the same order as opts.ageTiers
+	byAge []objectGroup
+	// byStorageClass groups the objects in each storage class, in the same order as
+	// opts.storageClasses
+	byStorageClass []objectGroup
+	// storageClassKnown is whether any object's backend reported its storage class
+	storageClassKnown bool
+	// dirs counts the bucket's directories, if dirsCounted
+	dirs int64
+	// dirsCounted is whether directories were counted, which they aren't unless enabled, nor on
+	// backends without real directories unless pseudo-directories are counted too
+	dirsCounted bool
+	// objects counts every object walked, including those with an unknown size
+	objects int64
+	// size sums the sizes of the objects walked, with unknown sizes treated as opts.unknownSizePolicy
+	size int64
+	// noHash counts objects without a hash of the backend's preferred type
+	noHash int64
+	// noModTime counts objects whose modification time is unknown, which are left out of byAge
+	noModTime int64
+	// sizes accumulates the distribution of object sizes
+	sizes welford
+	// byFilter groups the objects matching each object filter, in the same order as
+	// opts.objectFilters
+	byFilter []objectGroup
+}
+
+// walkBucket walks every object in the bucket once, feeding each object to the enabled collectors.
+// The walk is only needed by collectors that look at individual objects, so callers should only
+// run it when at least one of them is enabled
+func walkBucket(ctx context.Context, f fs.Fs, opts *options) (*bucketWalk, error) {
+	result := &bucketWalk{
+		byAge:          make([]objectGroup, len(opts.ageTiers)),
+		byFilter:       make([]objectGroup, len(opts.objectFilters)),
+		byStorageClass: make([]objectGroup, len(opts.storageClasses)),
+	}
+	now := opts.clock.Now()
+	// Objects left out of the count are left out of the walk too, so the two agree
+	minAge := newMinAgeCutoff(opts)
+	if len(opts.ageTiers) > 0 || minAge != nil {
+		ctx = withServerModTime(ctx)
+	}
+	defaultTime := time.Time(fs.GetConfig(ctx).DefaultTime)
+	// Backends supporting no hash at all leave every object unverifiable
+	hashType := f.Hashes().GetOne()
+	// Directories of backends that can't have empty ones are synthesized from object names
+	result.dirsCounted = opts.dirCount && (opts.countPseudoDirs || f.Features().CanHaveEmptyDirectories)
+	// Only the largest groups are exported, so tracking every distinct value isn't worth the memory
+	metadataCapacity := opts.metadataMaxTracked
+	if metadataCapacity > 0 && opts.metadataTopN > 0 {
+		metadataCapacity = max(metadataCapacity, opts.metadataTopN)
+	}
+	byMetadata := newTopGroupTracker(metadataCapacity)
+	listType := walk.ListObjects
+	if result.dirsCounted {
+		listType = walk.ListAll
+	}
+	err := walk.ListR(ctx, f, "", false, -1, listType, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			if _, ok := entry.(fs.Directory); ok {
+				result.dirs++
+				continue
+			}
+			o, ok := entry.(fs.Object)
+			if !ok || minAge.recent(ctx, o) {
+				continue
+			}
+			result.objects++
+			size := o.Size()
+			sized := size >= 0
+			if !sized {
+				switch opts.unknownSizePolicy {
+				case unknownSizeSkip:
+					// Counted by every collector, just without adding to any size
+					size = 0
+				case unknownSizeZero:
+					size, sized = 0, true
+				case unknownSizeError:
+					return fmt.Errorf("object %q has an unknown size", o.Remote())
+				}
+			}
+			result.size += size
+			if opts.sizeStddev && sized {
+				result.sizes.add(float64(size))
+			}
+			for i, objectFilter := range opts.objectFilters {
+				if objectFilter.filter.IncludeRemote(o.Remote()) {
+					result.byFilter[i].count++
+					result.byFilter[i].size += size
+				}
+			}
+			if len(opts.storageClasses) > 0 {
+				if class := storageClass(o); class != "" {
+					result.storageClassKnown = true
+					for i, wanted := range opts.storageClasses {
+						if strings.EqualFold(class, wanted) {
+							result.byStorageClass[i].count++
+							result.byStorageClass[i].size += size
+						}
+					}
+				}
+			}
+			if opts.metadataKey != "" {
+				metadata, err := fs.GetMetadata(ctx, o)
+				if err != nil {
+					return err
+				}
+				value, ok := metadata[opts.metadataKey]
+				if !ok {
+					value = metadataValueNone
+				}
+				byMetadata.add(value, size)
+			}
+			if opts.hashPresence {
+				if hashType == hash.None {
+					result.noHash++
+				} else if sum, err := o.Hash(ctx, hashType); err != nil || sum == "" {
+					result.noHash++
+				}
+			}
+			if len(opts.ageTiers) > 0 {
+				// Backends without modification times report the zero time or rclone's default
+				modTime := o.ModTime(ctx)
+				if modTime.IsZero() || modTime.Equal(defaultTime) {
+					if result.noModTime == 0 {
+						logrus.WithField("object", o.String()).Debug("object has no modification time")
+					}
+					result.noModTime++
+				} else {
+					age := now.Sub(modTime)
+					for i, tier := range opts.ageTiers {
+						if tier.max == 0 || age < tier.max {
+							result.byAge[i].count++
+							result.byAge[i].size += size
+							break
+						}
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.byMetadata, result.byMetadataMaxError = byMetadata.top(opts.metadataTopN)
+	return result, nil
+}